@@ -10,10 +10,18 @@ import (
 
 	"vpn-bot/internal/bot"
 	"vpn-bot/internal/config"
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/marzban"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/outline"
 	"vpn-bot/internal/panel"
 	"vpn-bot/internal/panel/auth"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/reconcile"
 	"vpn-bot/internal/scheduler"
+	"vpn-bot/internal/sharing"
 	"vpn-bot/internal/storage"
+	"vpn-bot/internal/templates"
 )
 
 func main() {
@@ -29,34 +37,167 @@ func main() {
 	defer db.Close()
 
 	store := storage.New(db)
-	auth.Configure(cfg.PanelURL, cfg.PanelUser, cfg.PanelPass)
-
-	sessionCookie, err := auth.LoginAndGetSession()
+	servers := make(map[string]provision.Provisioner)
+	var pendingLogin *auth.SessionAuth
+	if cfg.PanelURL != "" {
+		var xui *provision.XUI
+		xui, pendingLogin = newXUI(cfg, store)
+		servers["main"] = xui
+	}
+	for _, server := range cfg.Servers {
+		switch server.Type {
+		case "marzban":
+			servers[server.Name] = provision.NewMarzban(marzban.New(server.URL, server.User, server.Pass))
+		case "wgeasy":
+			servers[server.Name] = provision.NewWGEasy(server.URL, server.Pass)
+		case "outline":
+			client, err := outline.New(server.URL, server.CertSHA256)
+			if err != nil {
+				log.Fatalf("server %s: %v", server.Name, err)
+			}
+			servers[server.Name] = provision.NewOutline(client)
+		}
+	}
+	provisioner, err := provision.NewPool(cfg.PrimaryServer, cfg.DefaultServer, servers)
 	if err != nil {
-		log.Fatalf("panel login: %v", err)
+		log.Fatalf("servers: %v", err)
 	}
+	provisioner.SetRetryPolicy(provision.RetryPolicy{
+		Attempts:  cfg.PanelRetries,
+		BaseDelay: cfg.PanelRetryDelay,
+		MaxDelay:  10 * cfg.PanelRetryDelay,
+	})
+	provisioner.SetBreakerPolicy(provision.BreakerPolicy{
+		Failures: cfg.PanelBreakerFailures,
+		Cooldown: cfg.PanelBreakerCooldown,
+	})
 
-	panelClient := panel.New(cfg.PanelURL, sessionCookie)
 
+	catalog, err := i18n.Load()
+	if err != nil {
+		log.Fatalf("load messages: %v", err)
+	}
+	tmpl := templates.New(store, catalog)
+	if err := tmpl.Load(context.Background()); err != nil {
+		log.Fatalf("load templates: %v", err)
+	}
+	catalog.SetOverrides(tmpl)
 
 	api, err := tgbotapi.NewBotAPI(cfg.TelegramToken)
 	if err != nil {
 		log.Fatalf("new bot: %v", err)
 	}
 
-	b := bot.New(api, store, panelClient, cfg.AdminIDs)
+	source, err := reconcile.ParseSource(cfg.ReconcileSource)
+	if err != nil {
+		log.Fatalf("reconcile source: %v", err)
+	}
+	reconciler := reconcile.New(provisioner, store, source)
+
+	var detector *sharing.Detector
+	if cfg.SharingSchedule != "" {
+		action, err := sharing.ParseAction(cfg.SharingAction)
+		if err != nil {
+			log.Fatalf("sharing action: %v", err)
+		}
+		detector = sharing.New(provisioner, store, sharing.Options{
+			Window:    cfg.SharingWindow,
+			Tolerance: cfg.SharingTolerance,
+			ByASN:     cfg.SharingByASN,
+			Action:    action,
+		})
+	}
+
+	out := outbox.New(api, store)
+	b := bot.New(api, out, store, provisioner, catalog, tmpl, bot.Options{
+		AdminIDs:            cfg.AdminIDs,
+		GuideMediaURL:       cfg.GuideMediaURL,
+		RedirectURL:         cfg.RedirectURL,
+		SupportChatID:       cfg.SupportChatID,
+		SupportThreadID:     cfg.SupportThreadID,
+		KeyRotationInterval: cfg.KeyRotationInterval,
+		TrialDays:           cfg.TrialDays,
+		Reconciler:          reconciler,
+		Sharing:             detector,
+	})
+
+	provisioner.OnAvailabilityChange(b.PanelAvailabilityChanged)
 
 	sched := scheduler.New()
 	if err := sched.ScheduleDailyNotifications(b); err != nil {
 		log.Fatalf("schedule notifications: %v", err)
 	}
+	if err := sched.ScheduleRenewalRetries(b); err != nil {
+		log.Fatalf("schedule renewal retries: %v", err)
+	}
+	if err := sched.ScheduleClientExpiry(provisioner); err != nil {
+		log.Fatalf("schedule client expiry: %v", err)
+	}
+	if cfg.SharingSchedule != "" {
+		if err := sched.ScheduleSharingChecks(cfg.SharingSchedule, b); err != nil {
+			log.Fatalf("schedule sharing checks: %v", err)
+		}
+	}
+	if cfg.ReconcileSchedule != "" {
+		if err := sched.ScheduleReconciliation(cfg.ReconcileSchedule, b); err != nil {
+			log.Fatalf("schedule reconciliation: %v", err)
+		}
+	}
 	sched.Start()
 	defer sched.Stop()
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	if pendingLogin != nil {
+		go pendingLogin.LoginInBackground(ctx)
+	}
+
+	go func() {
+		if err := out.Run(ctx); err != nil && err != context.Canceled {
+			log.Printf("outbox stopped: %v", err)
+		}
+	}()
+
 	if err := b.Run(ctx); err != nil && err != context.Canceled {
 		log.Printf("bot stopped: %v", err)
 	}
 }
+
+// newXUI connects to the 3x-ui panel at PANEL_URL. If the panel cannot be
+// logged in to yet, the session is returned to keep trying in the
+// background.
+func newXUI(cfg *config.Config, store *storage.Storage) (*provision.XUI, *auth.SessionAuth) {
+	var panelAuth auth.Authenticator
+	var pendingLogin *auth.SessionAuth
+	if cfg.PanelAuth == "token" {
+		panelAuth = auth.NewTokenAuth(cfg.PanelToken)
+	} else {
+		session, err := auth.NewSessionAuth(auth.Credentials{
+			URL:        cfg.PanelURL,
+			Username:   cfg.PanelUser,
+			Password:   cfg.PanelPass,
+			TOTPSecret: cfg.PanelTOTPSecret,
+			SessionKey: cfg.PanelSessionKey,
+		}, store)
+		if err != nil {
+			log.Fatalf("panel auth: %v", err)
+		}
+		if cfg.PanelSessionKey == "" {
+			log.Printf("PANEL_SESSION_KEY is not set: the panel session is not saved across restarts")
+		}
+		restored, err := session.Restore(context.Background())
+		if err != nil {
+			log.Printf("restore panel session: %v", err)
+		}
+		if !restored {
+			if _, err := session.Login(context.Background()); err != nil {
+				// The panel may be briefly down; keep trying once the bot runs.
+				log.Printf("panel login: %v", err)
+				pendingLogin = session
+			}
+		}
+		panelAuth = session
+	}
+	return provision.NewXUI(panel.New(cfg.PanelURL, panelAuth), cfg.SubscriptionURL), pendingLogin
+}
@@ -3,7 +3,16 @@ module vpn-bot
 go 1.21
 
 require (
-github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
-github.com/jackc/pgx/v5 v5.5.4
-github.com/robfig/cron/v3 v3.0.1
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	github.com/jackc/pgx/v5 v5.5.4
+	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/sync v0.1.0
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/puddle/v2 v2.2.1 // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
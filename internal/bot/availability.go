@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+// authAlertInterval limits alerts about rejected panel credentials, which
+// every failing request would otherwise repeat.
+const authAlertInterval = time.Hour
+
+// PanelAvailabilityChanged alerts admins when a server's circuit breaker
+// opens and when the server recovers.
+func (b *Bot) PanelAvailabilityChanged(server string, available bool, err error) {
+	loc := b.defaultLoc()
+	text := loc.T("admin.panel.up", i18n.Args{"Server": server})
+	if !available {
+		text = loc.T("admin.panel.down", i18n.Args{"Server": server, "Error": err.Error()})
+	}
+	for adminID := range b.admins {
+		b.reply(adminID, text)
+	}
+}
+
+// panelFailed returns the reply for a failed panel call. Users are told to
+// retry later when the panel is down or rejects the bot, which admins are
+// alerted about, and to contact support when their key is gone from the
+// panel; other errors get the message key.
+func (b *Bot) panelFailed(loc *i18n.Localizer, err error, key string) string {
+	switch {
+	case errors.Is(err, provision.ErrUnauthorized):
+		b.alertUnauthorized(err)
+		return loc.T("panel.unavailable")
+	case errors.Is(err, provision.ErrUnavailable):
+		return loc.T("panel.unavailable")
+	case errors.Is(err, provision.ErrNotFound):
+		return loc.T("panel.key_missing")
+	}
+	return loc.T(key)
+}
+
+func (b *Bot) alertUnauthorized(err error) {
+	b.mu.Lock()
+	if time.Since(b.authAlertAt) < authAlertInterval {
+		b.mu.Unlock()
+		return
+	}
+	b.authAlertAt = time.Now()
+	b.mu.Unlock()
+
+	log.Printf("panel rejected credentials: %v", err)
+	text := b.defaultLoc().T("admin.panel.unauthorized", i18n.Args{"Error": err.Error()})
+	for adminID := range b.admins {
+		b.reply(adminID, text)
+	}
+}
+
+// serverOf returns the server holding the key, or "" for the default one.
+func (b *Bot) serverOf(keyID string) string {
+	if l, ok := b.panel.(provision.Locator); ok {
+		return l.ServerOf(keyID)
+	}
+	return ""
+}
+
+// userServer returns the server of the user's main key, or of a device key
+// if the main key is gone, so new keys join the user's other keys.
+func (b *Bot) userServer(ctx context.Context, user *storage.User) string {
+	if user.KeyID.Valid {
+		return b.serverOf(user.KeyID.String)
+	}
+	devices, err := b.store.ListDevices(ctx, user.ID)
+	if err != nil {
+		log.Printf("list devices: %v", err)
+		return ""
+	}
+	if len(devices) > 0 {
+		return b.serverOf(devices[0].KeyID)
+	}
+	return ""
+}
+
+// deleteClient deletes a panel client, treating one that is already gone
+// as deleted.
+func (b *Bot) deleteClient(ctx context.Context, keyID string) error {
+	err := b.panel.DeleteClient(ctx, keyID)
+	if errors.Is(err, provision.ErrNotFound) {
+		return nil
+	}
+	return err
+}
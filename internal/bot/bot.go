@@ -2,40 +2,94 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
-	"vpn-bot/internal/panel"
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/reconcile"
+	"vpn-bot/internal/sharing"
 	"vpn-bot/internal/storage"
+	"vpn-bot/internal/templates"
 )
 
+// Options holds optional settings of the bot.
+type Options struct {
+	AdminIDs []int64
+	// GuideMediaURL is the base address of setup guide screenshots.
+	GuideMediaURL string
+	// RedirectURL is an https page redirecting to its "to" query parameter.
+	// Telegram buttons only accept http(s) links, so app import deep links
+	// go through it.
+	RedirectURL string
+	// SupportChatID is the group receiving support tickets, optionally a
+	// forum topic of it. Zero means tickets go to admins' private chats.
+	SupportChatID   int64
+	SupportThreadID int
+	// KeyRotationInterval is the minimum time between key rotations.
+	KeyRotationInterval time.Duration
+	// TrialDays is the length of the one-time free key. Zero disables
+	// trials, so keys are only issued after a payment.
+	TrialDays int
+	// Reconciler compares the database with the panel for /reconcile and
+	// the scheduled check.
+	Reconciler *reconcile.Reconciler
+	// Sharing detects shared keys in the scheduled check; nil disables it.
+	Sharing *sharing.Detector
+}
+
 type Bot struct {
 	api             *tgbotapi.BotAPI
+	outbox          *outbox.Outbox
 	store           *storage.Storage
-	panel           *panel.Client
+	panel           provision.Provisioner
+	i18n            *i18n.Catalog
+	templates       *templates.Cache
+	opts            Options
 	admins          map[int64]struct{}
 	awaitingComment map[int64]int
+	broadcasts      map[int64]*broadcastDraft
+	editingTemplate map[int64]templateTarget
+	supportMode     map[int64]int
+	addingDevice    map[int64]struct{}
+	buyingGift      map[int64]struct{}
+	sentGuides      map[guideChat]struct{}
+	authAlertAt     time.Time
+	callbacks       *router
 	mu              sync.Mutex
 }
 
-func New(api *tgbotapi.BotAPI, store *storage.Storage, panel *panel.Client, adminIDs []int64) *Bot {
+func New(api *tgbotapi.BotAPI, out *outbox.Outbox, store *storage.Storage, panel provision.Provisioner, catalog *i18n.Catalog, tmpl *templates.Cache, opts Options) *Bot {
 	admins := make(map[int64]struct{})
-	for _, id := range adminIDs {
+	for _, id := range opts.AdminIDs {
 		admins[id] = struct{}{}
 	}
-	return &Bot{
+	b := &Bot{
 		api:             api,
+		outbox:          out,
 		store:           store,
 		panel:           panel,
+		i18n:            catalog,
+		templates:       tmpl,
+		opts:            opts,
 		admins:          admins,
 		awaitingComment: make(map[int64]int),
-	}
+		broadcasts:      make(map[int64]*broadcastDraft),
+		editingTemplate: make(map[int64]templateTarget),
+		supportMode:     make(map[int64]int),
+		addingDevice:    make(map[int64]struct{}),
+		buyingGift:      make(map[int64]struct{}),
+		sentGuides:      make(map[guideChat]struct{}),
+	}
+	b.registerCallbacks()
+	return b
 }
 
 func (b *Bot) Run(ctx context.Context) error {
@@ -43,6 +97,10 @@ func (b *Bot) Run(ctx context.Context) error {
 	updateConfig.Timeout = 30
 	updates := b.api.GetUpdatesChan(updateConfig)
 
+	b.registerCommands()
+	b.resumeBroadcasts(ctx)
+	go b.RetryRenewals(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -57,6 +115,7 @@ func (b *Bot) handleUpdate(ctx context.Context, update tgbotapi.Update) {
 	if update.Message != nil {
 		msg := update.Message
 		switch {
+		case b.handleSupportReply(ctx, msg):
 		case msg.IsCommand():
 			b.handleCommand(ctx, msg)
 		case msg.Photo != nil:
@@ -75,15 +134,77 @@ func (b *Bot) handleCommand(ctx context.Context, msg *tgbotapi.Message) {
 	switch msg.Command() {
 	case "start":
 		b.handleStart(ctx, msg)
+	case "menu":
+		b.handleMenu(ctx, msg)
 	case "getkey":
 		b.handleGetKey(ctx, msg)
+	case "rotatekey":
+		b.handleRotateKey(ctx, msg)
+	case "devices":
+		b.handleDevices(ctx, msg)
+	case "family":
+		b.handleFamily(ctx, msg)
+	case "gift":
+		b.handleGift(ctx, msg)
 	case "status":
 		b.handleStatus(ctx, msg)
 	case "help":
-		b.handleHelp(msg.Chat.ID)
+		b.handleHelp(ctx, msg)
+	case "language":
+		b.handleLanguage(ctx, msg)
+	case "support":
+		b.handleSupport(ctx, msg)
+	case "close":
+		b.handleCloseTicket(ctx, msg)
+	case "skip":
+		b.skipBroadcastButtons(msg)
+	case "cancel":
+		b.cancelBroadcast(msg)
+		if b.cancelDeviceAdd(msg) || b.cancelGift(msg) || b.cancelSupport(msg) || b.cancelTemplateEdit(msg) {
+			b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.cancelled"))
+		}
+	default:
+		if b.isAdmin(msg.From.ID) && b.handleAdminCommand(ctx, msg) {
+			return
+		}
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.unknown_command"))
+	}
+}
+
+// handleAdminCommand dispatches commands available only to admins and
+// reports whether the command was recognised.
+func (b *Bot) handleAdminCommand(ctx context.Context, msg *tgbotapi.Message) bool {
+	switch msg.Command() {
+	case "broadcast":
+		b.startBroadcast(msg)
+	case "templates":
+		b.handleTemplates(msg)
+	case "template":
+		b.handleTemplate(msg)
+	case "settemplate":
+		b.handleSetTemplate(ctx, msg)
+	case "previewtemplate":
+		b.handlePreviewTemplate(msg)
+	case "resettemplate":
+		b.handleResetTemplate(ctx, msg)
+	case "setplan":
+		b.handleSetPlan(ctx, msg)
+	case "reconcile":
+		b.handleReconcile(ctx, msg)
+	case "online":
+		b.handleOnline(ctx, msg)
+	case "unsuspend":
+		b.handleUnsuspend(ctx, msg)
+	case "tickets":
+		b.handleTickets(ctx, msg)
+	case "closeticket":
+		b.handleAdminTicketCommand(ctx, msg, "closed")
+	case "reopen":
+		b.handleAdminTicketCommand(ctx, msg, "open")
 	default:
-		b.reply(msg.Chat.ID, "Неизвестная команда. Используйте /help")
+		return false
 	}
+	return true
 }
 
 func (b *Bot) handleStart(ctx context.Context, msg *tgbotapi.Message) {
@@ -94,96 +215,254 @@ func (b *Bot) handleStart(ctx context.Context, msg *tgbotapi.Message) {
 	user, err := b.store.UpsertUser(ctx, msg.From.ID, username)
 	if err != nil {
 		log.Printf("upsert user: %v", err)
-		b.reply(msg.Chat.ID, "Не удалось зарегистрироваться. Попробуйте позже")
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("start.failed"))
 		return
 	}
-	text := fmt.Sprintf("Вы зарегистрированы! Ваш ID в системе: %d", user.ID)
-	b.reply(msg.Chat.ID, text)
+	loc := b.loc(user, msg.From)
+	b.replyTemplate(msg.Chat.ID, loc, "start.registered", i18n.Args{"ID": user.ID})
+	switch arg := msg.CommandArguments(); {
+	case strings.HasPrefix(arg, familyPrefix):
+		b.replyHTML(msg.Chat.ID, b.joinFamily(ctx, loc, user, strings.TrimPrefix(arg, familyPrefix)))
+	case strings.HasPrefix(arg, giftPrefix):
+		b.replyHTML(msg.Chat.ID, b.redeemGift(ctx, loc, user, strings.TrimPrefix(arg, giftPrefix)))
+	}
+	b.showMenu(msg.Chat.ID, loc)
 }
 
 func (b *Bot) handleGetKey(ctx context.Context, msg *tgbotapi.Message) {
 	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
 	if err != nil || user == nil {
-		b.reply(msg.Chat.ID, "Сначала выполните /start")
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
 		return
 	}
-	key, err := b.panel.AddClient(ctx, user.ID)
+	b.replyHTML(msg.Chat.ID, b.issueKey(ctx, b.loc(user, msg.From), user))
+}
+
+// issueKey returns the user's key, creating a panel client for paid and
+// trial users who have none yet. It returns the HTML reply.
+func (b *Bot) issueKey(ctx context.Context, loc *i18n.Localizer, user *storage.User) string {
+	if user.KeyID.Valid {
+		return b.existingKeyText(ctx, loc, user)
+	}
+
+	unlock, ok, err := b.store.TryLockUser(ctx, user.ID)
+	if err != nil {
+		log.Printf("lock user %d: %v", user.ID, err)
+		return loc.T("key.create_failed")
+	}
+	if !ok {
+		return loc.T("key.in_progress")
+	}
+	defer unlock()
+
+	// Re-read under the lock: a concurrent issuance may have just finished.
+	user, err = b.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		return loc.T("key.create_failed")
+	}
+	if user.KeyID.Valid {
+		return b.existingKeyText(ctx, loc, user)
+	}
+	if user.SuspendedAt.Valid {
+		return loc.T("sharing.suspended")
+	}
+
+	paid, err := b.store.HasConfirmedPayment(ctx, user.ID)
+	if err != nil {
+		log.Printf("check payments: %v", err)
+		return loc.T("key.create_failed")
+	}
+	var days int
+	trial := !paid
+	if paid {
+		plan, err := b.store.GetUserPlan(ctx, user.ID)
+		if err != nil {
+			log.Printf("get user plan: %v", err)
+			return loc.T("key.create_failed")
+		}
+		days = plan.Days
+	} else {
+		if user.TrialUsedAt.Valid || b.opts.TrialDays <= 0 {
+			return b.renderTemplate(loc, "key.no_access")
+		}
+		days = b.opts.TrialDays
+	}
+
+	expires := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	key, err := b.createKey(ctx, user, expires)
 	if err != nil {
 		log.Printf("panel add client: %v", err)
-		b.reply(msg.Chat.ID, "Не удалось создать ключ. Попробуйте позже")
+		return b.panelFailed(loc, err, "key.create_failed")
+	}
+	stored, err := b.store.IssueUserKey(ctx, user.ID, key, expires, trial)
+	if err != nil || !stored {
+		if err != nil {
+			log.Printf("issue user key: %v", err)
+		}
+		if err := b.panel.DeleteClient(ctx, key); err != nil {
+			log.Printf("panel delete client %s: %v", key, err)
+		}
+		return loc.T("key.create_failed")
+	}
+	b.sendKeyConfig(ctx, loc, user.TelegramID, key)
+	return b.renderTemplate(loc, "key.issued", i18n.Args{"Key": b.keyLink(ctx, key), "Expires": loc.Date(expires)})
+}
+
+// createKey creates the panel client for a user's main key on the server
+// holding their other keys.
+func (b *Bot) createKey(ctx context.Context, user *storage.User, expires time.Time) (string, error) {
+	server := b.userServer(ctx, user)
+	key, err := b.panel.CreateClient(ctx, provision.ClientSpec{UserID: user.ID, Expiry: expires, Server: server})
+	if errors.Is(err, provision.ErrDuplicate) {
+		// A client left from an earlier key holds the default email.
+		key, err = b.panel.CreateClient(ctx, provision.ClientSpec{
+			UserID: user.ID,
+			Email:  fmt.Sprintf("user-%d-%d@example.com", user.ID, time.Now().Unix()),
+			Expiry: expires,
+			Server: server,
+		})
+	}
+	return key, err
+}
+
+// existingKeyText returns the HTML text showing the user's key, sending its
+// configuration file first if the server gives one.
+func (b *Bot) existingKeyText(ctx context.Context, loc *i18n.Localizer, user *storage.User) string {
+	b.sendKeyConfig(ctx, loc, user.TelegramID, user.KeyID.String)
+	args := i18n.Args{"Key": b.keyLink(ctx, user.KeyID.String)}
+	if !user.ExpiresAt.Valid {
+		return b.renderTemplate(loc, "key.existing_unlimited", args)
+	}
+	args["Expires"] = loc.Date(user.ExpiresAt.Time)
+	if user.ExpiresAt.Time.Before(time.Now()) {
+		return b.renderTemplate(loc, "key.expired", args)
+	}
+	return b.renderTemplate(loc, "key.existing", args)
+}
+
+// keyLink returns what the user imports for a key: the panel's connection
+// link, or the key itself if the panel cannot build one.
+func (b *Bot) keyLink(ctx context.Context, keyID string) string {
+	link, err := b.panel.ConnectionLink(ctx, keyID)
+	if err != nil {
+		if !errors.Is(err, provision.ErrNotSupported) {
+			log.Printf("connection link of %s: %v", keyID, err)
+		}
+		return keyID
+	}
+	return link
+}
+
+// sendKeyConfig sends the configuration file of a key whose server gives
+// one instead of a link, such as WireGuard. The file holds the key's
+// private key, so it goes only to the chat of the key's owner.
+func (b *Bot) sendKeyConfig(ctx context.Context, loc *i18n.Localizer, chatID int64, keyID string) {
+	c, ok := b.panel.(provision.Configurer)
+	if !ok {
+		return
+	}
+	name, data, err := c.ClientConfig(ctx, keyID)
+	if errors.Is(err, provision.ErrNotSupported) {
+		return
+	}
+	if err != nil {
+		log.Printf("config of %s: %v", keyID, err)
+		b.reply(chatID, b.panelFailed(loc, err, "key.config_failed"))
 		return
 	}
-	expires := time.Now().Add(30 * 24 * time.Hour)
-	if err := b.store.UpdateUserKey(ctx, user.ID, key, expires); err != nil {
-		log.Printf("update user key: %v", err)
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+	doc.Caption = loc.T("key.config")
+	if _, err := b.api.Send(doc); err != nil {
+		log.Printf("send config of %s: %v", keyID, err)
 	}
-	b.reply(msg.Chat.ID, fmt.Sprintf("Ваш новый ключ: %s\nДействителен до %s", key, expires.Format("02.01.2006")))
 }
 
 func (b *Bot) handleStatus(ctx context.Context, msg *tgbotapi.Message) {
 	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
-	if err != nil || user == nil || !user.KeyID.Valid {
-		b.reply(msg.Chat.ID, "Ключ не найден. Запросите новый через /getkey")
-		return
+	if err != nil {
+		log.Printf("get user: %v", err)
 	}
+	b.replyHTML(msg.Chat.ID, b.subscriptionText(ctx, b.loc(user, msg.From), user))
+}
 
-	expires, err := b.panel.GetClientStatus(ctx, user.KeyID.String)
+func (b *Bot) subscriptionText(ctx context.Context, loc *i18n.Localizer, user *storage.User) string {
+	if user == nil || !user.KeyID.Valid {
+		return loc.T("status.no_key")
+	}
+
+	client, err := b.panel.GetClient(ctx, user.KeyID.String)
 	if err != nil {
 		log.Printf("panel get status: %v", err)
-		b.reply(msg.Chat.ID, "Не удалось получить статус. Попробуйте позже")
-		return
+		return b.panelFailed(loc, err, "status.failed")
 	}
-	b.reply(msg.Chat.ID, fmt.Sprintf("Ключ активен до %s", expires.Format("02.01.2006")))
-}
-
-func (b *Bot) handleHelp(chatID int64) {
-	helpText := "Инструкция по установке Xray/VLESS:\n" +
-		"iOS: используйте приложение Shadowrocket или Streisand.\n" +
-		"Android: V2rayNG или Nekobox.\n" +
-		"Windows: V2RayN.\n" +
-		"Linux/macOS: Xray-core через терминал."
-	b.reply(chatID, helpText)
+	expires := client.Expiry
+	if expires.IsZero() {
+		return loc.T("status.unlimited")
+	}
+	days := int(time.Until(expires).Hours() / 24)
+	return loc.N("status.active", days, i18n.Args{"Expires": loc.Date(expires)})
 }
 
 func (b *Bot) handlePhoto(ctx context.Context, msg *tgbotapi.Message) {
+	if b.isAdmin(msg.From.ID) && b.handleBroadcastPhoto(msg) {
+		return
+	}
+	if b.handleSupportMessage(ctx, msg) {
+		return
+	}
 	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
 	if err != nil || user == nil {
-		b.reply(msg.Chat.ID, "Сначала выполните /start")
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
 		return
 	}
 	if len(msg.Photo) == 0 {
 		return
 	}
+	loc := b.loc(user, msg.From)
 	photo := msg.Photo[len(msg.Photo)-1]
-	payment, err := b.store.CreatePayment(ctx, user.ID, photo.FileID)
+	gift := b.takeGiftMode(msg.From.ID)
+	payment, err := b.store.CreatePayment(ctx, user.ID, photo.FileID, gift)
 	if err != nil {
 		log.Printf("create payment: %v", err)
-		b.reply(msg.Chat.ID, "Не удалось сохранить оплату")
+		b.reply(msg.Chat.ID, loc.T("payment.save_failed"))
 		return
 	}
 
-	caption := fmt.Sprintf("Новый платеж от @%s (ID %d)", msg.From.UserName, user.ID)
+	adminLoc := b.defaultLoc()
+	captionKey := "admin.payment.new"
+	if gift {
+		captionKey = "admin.payment.new_gift"
+	}
+	caption := adminLoc.T(captionKey, i18n.Args{"Username": msg.From.UserName, "ID": user.ID})
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		[]tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("✅ Подтвердить", fmt.Sprintf("confirm:%d", payment.ID)),
-			tgbotapi.NewInlineKeyboardButtonData("❌ Отклонить", fmt.Sprintf("reject:%d", payment.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(adminLoc.T("admin.payment.confirm_button"), callbackData("confirm", payment.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(adminLoc.T("admin.payment.reject_button"), callbackData("reject", payment.ID)),
 		},
 	)
 
 	for adminID := range b.admins {
-		photoMsg := tgbotapi.NewPhoto(adminID, tgbotapi.FileID(photo.FileID))
-		photoMsg.Caption = caption
-		photoMsg.ReplyMarkup = keyboard
-		if _, err := b.api.Send(photoMsg); err != nil {
-			log.Printf("send admin photo: %v", err)
+		err := b.outbox.Enqueue(ctx, outbox.Message{
+			ChatID:      adminID,
+			Text:        caption,
+			PhotoFileID: photo.FileID,
+			Markup:      &keyboard,
+		})
+		if err != nil {
+			log.Printf("enqueue admin photo: %v", err)
 		}
 	}
 
-	b.reply(msg.Chat.ID, "Платеж отправлен на проверку")
+	b.reply(msg.Chat.ID, loc.T("payment.sent"))
 }
 
 func (b *Bot) handleText(ctx context.Context, msg *tgbotapi.Message) {
+	if b.handleDeviceName(ctx, msg) {
+		return
+	}
 	if !b.isAdmin(msg.From.ID) {
+		b.handleSupportMessage(ctx, msg)
 		return
 	}
 
@@ -195,6 +474,9 @@ func (b *Bot) handleText(ctx context.Context, msg *tgbotapi.Message) {
 	b.mu.Unlock()
 
 	if !waiting {
+		if !b.handleTemplateText(ctx, msg) {
+			b.handleBroadcastText(ctx, msg)
+		}
 		return
 	}
 
@@ -215,33 +497,8 @@ func (b *Bot) handleText(ctx context.Context, msg *tgbotapi.Message) {
 		return
 	}
 
-	b.reply(user.TelegramID, fmt.Sprintf("Оплата отклонена: %s", comment))
-	b.reply(msg.Chat.ID, "Комментарий отправлен пользователю")
-}
-
-func (b *Bot) handleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
-	if !b.isAdmin(callback.From.ID) {
-		return
-	}
-	data := callback.Data
-	parts := strings.Split(data, ":")
-	if len(parts) != 2 {
-		return
-	}
-	action := parts[0]
-	id, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return
-	}
-
-	switch action {
-	case "confirm":
-		b.confirmPayment(ctx, callback, id)
-	case "reject":
-		b.requestRejectReason(callback, id)
-	}
-
-	_, _ = b.api.Request(tgbotapi.NewCallback(callback.ID, ""))
+	b.reply(user.TelegramID, b.loc(user, nil).T("payment.rejected", i18n.Args{"Comment": comment}))
+	b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("admin.payment.comment_sent"))
 }
 
 func (b *Bot) confirmPayment(ctx context.Context, callback *tgbotapi.CallbackQuery, paymentID int) {
@@ -257,48 +514,58 @@ func (b *Bot) confirmPayment(ctx context.Context, callback *tgbotapi.CallbackQue
 		return
 	}
 
-	if !user.KeyID.Valid {
-		b.reply(user.TelegramID, "У вас нет активного ключа. Запросите /getkey")
+	if payment.IsGift {
+		b.confirmGift(ctx, callback, payment, user)
 		return
 	}
 
-	expires := time.Now().Add(30 * 24 * time.Hour)
-	if err := b.panel.UpdateClient(ctx, user.KeyID.String, 30); err != nil {
-		log.Printf("panel update: %v", err)
-		b.reply(user.TelegramID, "Не удалось продлить подписку. Свяжитесь с админом")
+	b.startRenewal(ctx, callback, payment, user)
+}
+
+func (b *Bot) paymentNotClaimed(callback *tgbotapi.CallbackQuery, err error) {
+	if errors.Is(err, storage.ErrPaymentProcessed) {
+		b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.already_processed"))
 		return
 	}
+	log.Printf("confirm payment: %v", err)
+	b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.confirm_failed"))
+}
 
-	if err := b.store.UpdateUserKey(ctx, user.ID, user.KeyID.String, expires); err != nil {
-		log.Printf("update user key: %v", err)
+// extendShared moves the expiry of the user's additional devices and family
+// members to expires, telling the user if some could not be renewed.
+func (b *Bot) extendShared(ctx context.Context, loc *i18n.Localizer, user *storage.User, expires time.Time) {
+	if err := b.extendDevices(ctx, user, expires); err != nil {
+		log.Printf("renew devices of user %d: %v", user.ID, err)
+		b.reply(user.TelegramID, loc.T("payment.devices_failed"))
 	}
-
-	if err := b.store.UpdatePaymentStatus(ctx, paymentID, "confirmed", nil); err != nil {
-		log.Printf("update payment status: %v", err)
+	if err := b.extendFamily(ctx, user, expires); err != nil {
+		log.Printf("renew family of user %d: %v", user.ID, err)
+		b.reply(user.TelegramID, loc.T("payment.family_failed"))
 	}
-
-	b.reply(user.TelegramID, fmt.Sprintf("Оплата подтверждена! Новый срок: %s", expires.Format("02.01.2006")))
-	b.editCallback(callback, "Оплата подтверждена")
+	b.notifyFamilyRenewed(ctx, user, expires)
 }
 
 func (b *Bot) requestRejectReason(callback *tgbotapi.CallbackQuery, paymentID int) {
 	b.mu.Lock()
 	b.awaitingComment[callback.From.ID] = paymentID
 	b.mu.Unlock()
-	b.editCallback(callback, "Отправьте причину отказа сообщением")
+	b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.ask_reason"))
 }
 
 func (b *Bot) editCallback(callback *tgbotapi.CallbackQuery, text string) {
 	msg := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text)
-	if _, err := b.api.Send(msg); err != nil {
+	if _, err := b.outbox.Send(context.Background(), callback.Message.Chat.ID, msg); err != nil {
 		log.Printf("edit message: %v", err)
 	}
 }
 
 func (b *Bot) reply(chatID int64, text string) {
-	msg := tgbotapi.NewMessage(chatID, text)
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("send message: %v", err)
+	b.send(outbox.Message{ChatID: chatID, Text: text})
+}
+
+func (b *Bot) send(msg outbox.Message) {
+	if err := b.outbox.Enqueue(context.Background(), msg); err != nil {
+		log.Printf("enqueue message: %v", err)
 	}
 }
 
@@ -318,8 +585,8 @@ func (b *Bot) NotifyRenewal(ctx context.Context, when time.Time) error {
 		if !user.ExpiresAt.Valid {
 			continue
 		}
-		text := fmt.Sprintf("Напоминание об оплате. Срок действия до %s", user.ExpiresAt.Time.Format("02.01.2006"))
-		b.reply(user.TelegramID, text)
+		loc := b.loc(&user, nil)
+		b.replyTemplate(user.TelegramID, loc, "renewal.reminder", i18n.Args{"Expires": loc.Date(user.ExpiresAt.Time)})
 	}
 	return nil
 }
@@ -0,0 +1,373 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/storage"
+)
+
+const broadcastReportInterval = 5 * time.Second
+
+type broadcastStep int
+
+const (
+	stepCompose broadcastStep = iota
+	stepButtons
+	stepSegment
+	stepDays
+	stepConfirm
+)
+
+type broadcastDraft struct {
+	step    broadcastStep
+	text    string
+	photoID string
+	buttons string
+	segment storage.Segment
+	days    int
+}
+
+var segments = []storage.Segment{
+	storage.SegmentAll,
+	storage.SegmentActive,
+	storage.SegmentExpired,
+	storage.SegmentExpiring,
+	storage.SegmentNeverPaid,
+}
+
+func isSegment(seg storage.Segment) bool {
+	for _, s := range segments {
+		if s == seg {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) startBroadcast(msg *tgbotapi.Message) {
+	b.mu.Lock()
+	b.broadcasts[msg.From.ID] = &broadcastDraft{step: stepCompose}
+	b.mu.Unlock()
+	b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("admin.broadcast.compose"))
+}
+
+func (b *Bot) cancelBroadcast(msg *tgbotapi.Message) {
+	b.mu.Lock()
+	_, ok := b.broadcasts[msg.From.ID]
+	delete(b.broadcasts, msg.From.ID)
+	b.mu.Unlock()
+	if ok {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("admin.broadcast.cancelled"))
+	}
+}
+
+func (b *Bot) draft(adminID int64) *broadcastDraft {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.broadcasts[adminID]
+}
+
+// handleBroadcastText consumes admin text while a broadcast is being composed.
+// It reports whether the message was handled.
+func (b *Bot) handleBroadcastText(ctx context.Context, msg *tgbotapi.Message) bool {
+	d := b.draft(msg.From.ID)
+	if d == nil {
+		return false
+	}
+	loc := b.fromLoc(msg.From)
+
+	switch d.step {
+	case stepCompose:
+		d.text = msg.Text
+		d.step = stepButtons
+		b.reply(msg.Chat.ID, loc.T("admin.broadcast.ask_buttons"))
+	case stepButtons:
+		if _, err := parseButtons(msg.Text); err != nil {
+			b.reply(msg.Chat.ID, loc.T("admin.broadcast.bad_buttons", i18n.Args{"Error": err}))
+			return true
+		}
+		d.buttons = msg.Text
+		d.step = stepSegment
+		b.askSegment(loc, msg.Chat.ID)
+	case stepDays:
+		days, err := strconv.Atoi(strings.TrimSpace(msg.Text))
+		if err != nil || days <= 0 {
+			b.reply(msg.Chat.ID, loc.T("admin.broadcast.bad_days"))
+			return true
+		}
+		d.days = days
+		b.previewBroadcast(ctx, loc, msg.Chat.ID, d)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleBroadcastPhoto accepts a photo as the body of the broadcast being composed.
+func (b *Bot) handleBroadcastPhoto(msg *tgbotapi.Message) bool {
+	d := b.draft(msg.From.ID)
+	if d == nil || d.step != stepCompose || len(msg.Photo) == 0 {
+		return false
+	}
+	d.photoID = msg.Photo[len(msg.Photo)-1].FileID
+	d.text = msg.Caption
+	d.step = stepButtons
+	b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("admin.broadcast.ask_buttons"))
+	return true
+}
+
+func (b *Bot) skipBroadcastButtons(msg *tgbotapi.Message) {
+	d := b.draft(msg.From.ID)
+	if d == nil || d.step != stepButtons {
+		return
+	}
+	d.step = stepSegment
+	b.askSegment(b.fromLoc(msg.From), msg.Chat.ID)
+}
+
+func (b *Bot) askSegment(loc *i18n.Localizer, chatID int64) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, seg := range segments {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("admin.broadcast.segment."+string(seg)), callbackData("bcast", seg)),
+		))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.send(outbox.Message{ChatID: chatID, Text: loc.T("admin.broadcast.ask_segment"), Markup: &markup})
+}
+
+func (b *Bot) handleBroadcastCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, arg string) {
+	adminID := callback.From.ID
+	loc := b.fromLoc(callback.From)
+	d := b.draft(adminID)
+	if d == nil {
+		b.editCallback(callback, loc.T("admin.broadcast.no_draft"))
+		return
+	}
+
+	switch arg {
+	case "send":
+		if d.step != stepConfirm {
+			return
+		}
+		b.mu.Lock()
+		delete(b.broadcasts, adminID)
+		b.mu.Unlock()
+		b.launchBroadcast(ctx, loc, callback, d)
+	case "cancel":
+		b.mu.Lock()
+		delete(b.broadcasts, adminID)
+		b.mu.Unlock()
+		b.editCallback(callback, loc.T("admin.broadcast.cancelled"))
+	default:
+		seg := storage.Segment(arg)
+		if !isSegment(seg) || d.step != stepSegment {
+			return
+		}
+		d.segment = seg
+		if seg == storage.SegmentExpiring {
+			d.step = stepDays
+			b.editCallback(callback, loc.T("admin.broadcast.ask_days"))
+			return
+		}
+		b.editCallback(callback, loc.T("admin.broadcast.recipients", i18n.Args{"Segment": loc.T("admin.broadcast.segment." + arg)}))
+		b.previewBroadcast(ctx, loc, callback.Message.Chat.ID, d)
+	}
+}
+
+func (b *Bot) previewBroadcast(ctx context.Context, loc *i18n.Localizer, chatID int64, d *broadcastDraft) {
+	count, err := b.store.CountSegment(ctx, d.segment, d.days)
+	if err != nil {
+		log.Printf("count segment: %v", err)
+		b.reply(chatID, loc.T("admin.broadcast.count_failed"))
+		return
+	}
+	d.step = stepConfirm
+
+	markup, _ := parseButtons(d.buttons)
+	if _, err := b.outbox.Send(ctx, chatID, buildBroadcastMessage(chatID, d.text, d.photoID, markup)); err != nil {
+		log.Printf("send broadcast preview: %v", err)
+		b.reply(chatID, loc.T("admin.broadcast.preview_failed", i18n.Args{"Error": err}))
+		return
+	}
+
+	confirm := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("admin.broadcast.send_button"), callbackData("bcast", "send")),
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("admin.broadcast.cancel_button"), callbackData("bcast", "cancel")),
+	))
+	b.send(outbox.Message{
+		ChatID: chatID,
+		Text:   loc.N("admin.broadcast.confirm", count),
+		Markup: &confirm,
+	})
+}
+
+func (b *Bot) launchBroadcast(ctx context.Context, loc *i18n.Localizer, callback *tgbotapi.CallbackQuery, d *broadcastDraft) {
+	bc := &storage.Broadcast{
+		AdminID:     callback.From.ID,
+		Text:        sql.NullString{String: d.text, Valid: d.text != ""},
+		PhotoFileID: sql.NullString{String: d.photoID, Valid: d.photoID != ""},
+		Buttons:     sql.NullString{String: d.buttons, Valid: d.buttons != ""},
+		Segment:     d.segment,
+		SegmentDays: d.days,
+	}
+	created, err := b.store.CreateBroadcast(ctx, bc)
+	if err != nil {
+		log.Printf("create broadcast: %v", err)
+		b.editCallback(callback, loc.T("admin.broadcast.create_failed"))
+		return
+	}
+
+	b.editCallback(callback, loc.T("admin.broadcast.started", i18n.Args{"ID": created.ID}))
+	if err := b.store.SetBroadcastProgressMessage(ctx, created.ID, callback.Message.MessageID); err != nil {
+		log.Printf("set broadcast progress message: %v", err)
+	}
+	go b.runBroadcast(ctx, created.ID)
+}
+
+// resumeBroadcasts restarts deliveries interrupted by a shutdown.
+func (b *Bot) resumeBroadcasts(ctx context.Context) {
+	list, err := b.store.ListBroadcastsByStatus(ctx, "sending")
+	if err != nil {
+		log.Printf("list broadcasts: %v", err)
+		return
+	}
+	for _, bc := range list {
+		go b.runBroadcast(ctx, bc.ID)
+	}
+}
+
+func (b *Bot) runBroadcast(ctx context.Context, id int) {
+	bc, err := b.store.GetBroadcast(ctx, id)
+	if err != nil {
+		log.Printf("get broadcast %d: %v", id, err)
+		return
+	}
+	markup, _ := parseButtons(bc.Buttons.String)
+
+	lastReport := time.Now()
+
+	for {
+		batch, err := b.store.ListPendingRecipients(ctx, id, 100)
+		if err != nil {
+			log.Printf("list broadcast recipients: %v", err)
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, r := range batch {
+			status, errText := b.deliverBroadcast(ctx, bc, markup, r)
+			if ctx.Err() != nil {
+				// Leave the recipient pending so the delivery resumes after restart.
+				return
+			}
+			if err := b.store.UpdateRecipientStatus(ctx, id, r.UserID, status, errText); err != nil {
+				log.Printf("update broadcast recipient: %v", err)
+				return
+			}
+			if time.Since(lastReport) >= broadcastReportInterval {
+				b.reportBroadcast(ctx, bc, false)
+				lastReport = time.Now()
+			}
+		}
+	}
+
+	if err := b.store.FinishBroadcast(ctx, id, "done"); err != nil {
+		log.Printf("finish broadcast: %v", err)
+	}
+	b.reportBroadcast(ctx, bc, true)
+}
+
+func (b *Bot) deliverBroadcast(ctx context.Context, bc *storage.Broadcast, markup *tgbotapi.InlineKeyboardMarkup, r storage.BroadcastRecipient) (string, *string) {
+	msg := buildBroadcastMessage(r.TelegramID, bc.Text.String, bc.PhotoFileID.String, markup)
+	_, err := b.outbox.Send(ctx, r.TelegramID, msg)
+	if err == nil {
+		return "sent", nil
+	}
+	text := err.Error()
+	if outbox.IsBlocked(err) {
+		return "blocked", &text
+	}
+	return "failed", &text
+}
+
+func (b *Bot) reportBroadcast(ctx context.Context, bc *storage.Broadcast, final bool) {
+	st, err := b.store.GetBroadcastStats(ctx, bc.ID)
+	if err != nil {
+		log.Printf("broadcast stats: %v", err)
+		return
+	}
+	key := "admin.broadcast.progress"
+	if final {
+		key = "admin.broadcast.finished"
+	}
+	text := b.defaultLoc().T(key, i18n.Args{
+		"ID":      bc.ID,
+		"Sent":    st.Sent,
+		"Total":   st.Total,
+		"Failed":  st.Failed,
+		"Blocked": st.Blocked,
+	})
+
+	if !final && bc.ProgressMessageID.Valid {
+		edit := tgbotapi.NewEditMessageText(bc.AdminID, int(bc.ProgressMessageID.Int64), text)
+		if _, err := b.outbox.Send(ctx, bc.AdminID, edit); err != nil {
+			log.Printf("edit broadcast progress: %v", err)
+		}
+		return
+	}
+	b.reply(bc.AdminID, text)
+}
+
+func buildBroadcastMessage(chatID int64, text, photoID string, markup *tgbotapi.InlineKeyboardMarkup) tgbotapi.Chattable {
+	if photoID != "" {
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(photoID))
+		photo.Caption = text
+		if markup != nil {
+			photo.ReplyMarkup = *markup
+		}
+		return photo
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	return msg
+}
+
+// parseButtons parses "Text | URL" lines into a URL keyboard. Empty input
+// yields a nil keyboard.
+func parseButtons(s string) (*tgbotapi.InlineKeyboardMarkup, error) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		text, url, ok := strings.Cut(line, "|")
+		text, url = strings.TrimSpace(text), strings.TrimSpace(url)
+		if !ok || text == "" {
+			return nil, fmt.Errorf("line %q", line)
+		}
+		if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "tg://") {
+			return nil, fmt.Errorf("url %q", url)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(text, url)))
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup, nil
+}
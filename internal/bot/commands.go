@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+)
+
+var userCommands = []string{"start", "menu", "status", "getkey", "rotatekey", "devices", "family", "gift", "help", "language", "support"}
+
+var adminCommands = []string{"broadcast", "templates", "tickets", "setplan", "reconcile", "online", "unsuspend"}
+
+func (b *Bot) commandList(loc *i18n.Localizer, names []string) []tgbotapi.BotCommand {
+	commands := make([]tgbotapi.BotCommand, 0, len(names))
+	for _, name := range names {
+		commands = append(commands, tgbotapi.BotCommand{
+			Command:     name,
+			Description: loc.T("commands." + name),
+		})
+	}
+	return commands
+}
+
+// registerCommands publishes the command menu in every catalog language.
+// Admins additionally see the admin commands in their private chat.
+func (b *Bot) registerCommands() {
+	scope := tgbotapi.NewBotCommandScopeDefault()
+	defaultLoc := b.defaultLoc()
+	if _, err := b.api.Request(tgbotapi.NewSetMyCommandsWithScope(scope, b.commandList(defaultLoc, userCommands)...)); err != nil {
+		log.Printf("set commands: %v", err)
+	}
+	for _, lang := range b.i18n.Languages() {
+		cfg := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(scope, lang, b.commandList(b.i18n.For(lang), userCommands)...)
+		if _, err := b.api.Request(cfg); err != nil {
+			log.Printf("set commands for %s: %v", lang, err)
+		}
+	}
+
+	all := append(append([]string{}, userCommands...), adminCommands...)
+	for adminID := range b.admins {
+		cfg := tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeChat(adminID), b.commandList(defaultLoc, all)...)
+		if _, err := b.api.Request(cfg); err != nil {
+			log.Printf("set admin commands for %d: %v", adminID, err)
+		}
+	}
+}
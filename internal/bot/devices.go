@@ -0,0 +1,322 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+const (
+	maxDeviceNameLen = 32
+	// primaryDeviceID stands for User.KeyID in callback data.
+	primaryDeviceID = 0
+)
+
+func (b *Bot) handleDevices(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
+		return
+	}
+	loc := b.loc(user, msg.From)
+	text, markup := b.devicesScreen(ctx, loc, user)
+	b.send(outbox.Message{ChatID: msg.Chat.ID, Text: text, ParseMode: tgbotapi.ModeHTML, Markup: &markup})
+}
+
+// devicesScreen lists the user's device keys with buttons to show or remove
+// each of them and to add another one within the plan limit.
+func (b *Bot) devicesScreen(ctx context.Context, loc *i18n.Localizer, user *storage.User) (string, tgbotapi.InlineKeyboardMarkup) {
+	back := backMarkup(loc)
+	if !user.KeyID.Valid {
+		return loc.T("devices.no_key"), back
+	}
+	plan, err := b.store.GetUserPlan(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user plan: %v", err)
+		return loc.T("devices.failed"), back
+	}
+	devices, err := b.store.ListDevices(ctx, user.ID)
+	if err != nil {
+		log.Printf("list devices: %v", err)
+		return loc.T("devices.failed"), back
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"🔑 "+loc.T("devices.primary"), callbackData("devices", "key", primaryDeviceID))),
+	}
+	for _, d := range devices {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔑 "+d.Name, callbackData("devices", "key", d.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("🗑", callbackData("devices", "remove", d.ID)),
+		))
+	}
+	used := len(devices) + 1
+	if used < plan.Devices {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("devices.add_button"), callbackData("devices", "add"))))
+	}
+	rows = append(rows, back.InlineKeyboard...)
+
+	text := loc.N("devices.title", plan.Devices, i18n.Args{"Plan": html.EscapeString(plan.Title), "Used": used})
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (b *Bot) handleDevicesCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, args []string) {
+	user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil || user == nil {
+		b.editMenu(callback, b.fromLoc(callback.From).T("common.start_first"), nil)
+		return
+	}
+	loc := b.loc(user, callback.From)
+	if len(args) == 0 {
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		b.showDevices(ctx, callback, loc, user)
+	case "add":
+		b.askDeviceName(ctx, callback, loc, user)
+	case "key", "remove", "delete":
+		if len(args) != 2 {
+			return
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return
+		}
+		b.handleDeviceAction(ctx, callback, loc, user, args[0], id)
+	}
+}
+
+func (b *Bot) showDevices(ctx context.Context, callback *tgbotapi.CallbackQuery, loc *i18n.Localizer, user *storage.User) {
+	text, markup := b.devicesScreen(ctx, loc, user)
+	b.editMenu(callback, text, &markup)
+}
+
+func (b *Bot) handleDeviceAction(ctx context.Context, callback *tgbotapi.CallbackQuery, loc *i18n.Localizer, user *storage.User, action string, id int) {
+	if action == "key" && id == primaryDeviceID {
+		if user.KeyID.Valid {
+			b.replyHTML(callback.From.ID, b.existingKeyText(ctx, loc, user))
+		}
+		return
+	}
+
+	device, err := b.store.GetDevice(ctx, id)
+	if err != nil || device.UserID != user.ID {
+		b.showDevices(ctx, callback, loc, user)
+		return
+	}
+	name := html.EscapeString(device.Name)
+	switch action {
+	case "key":
+		b.sendKeyConfig(ctx, loc, callback.From.ID, device.KeyID)
+		b.replyHTML(callback.From.ID, loc.T("devices.key", i18n.Args{"Name": name, "Key": html.EscapeString(b.keyLink(ctx, device.KeyID))}))
+	case "remove":
+		markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("devices.delete_button"), callbackData("devices", "delete", device.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("devices", "list")),
+		))
+		b.editMenu(callback, loc.T("devices.confirm_delete", i18n.Args{"Name": name}), &markup)
+	case "delete":
+		if err := b.deleteClient(ctx, device.KeyID); err != nil {
+			log.Printf("panel delete device %d: %v", device.ID, err)
+			b.editMenu(callback, b.panelFailed(loc, err, "devices.failed"), nil)
+			return
+		}
+		if err := b.store.DeleteDevice(ctx, device.ID); err != nil {
+			log.Printf("delete device %d: %v", device.ID, err)
+		}
+		b.showDevices(ctx, callback, loc, user)
+	}
+}
+
+// askDeviceName switches the user to entering the name of a new device.
+func (b *Bot) askDeviceName(ctx context.Context, callback *tgbotapi.CallbackQuery, loc *i18n.Localizer, user *storage.User) {
+	if text, ok := b.canAddDevice(ctx, loc, user); !ok {
+		markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("devices", "list"))))
+		b.editMenu(callback, text, &markup)
+		return
+	}
+	b.mu.Lock()
+	b.addingDevice[callback.From.ID] = struct{}{}
+	b.mu.Unlock()
+	b.editMenu(callback, loc.T("devices.ask_name", i18n.Args{"Max": maxDeviceNameLen}), nil)
+}
+
+// canAddDevice checks the subscription and the plan's device limit. If a
+// device cannot be added it returns the explanation.
+func (b *Bot) canAddDevice(ctx context.Context, loc *i18n.Localizer, user *storage.User) (string, bool) {
+	if !user.KeyID.Valid {
+		return loc.T("devices.no_key"), false
+	}
+	if !user.ExpiresAt.Valid || user.ExpiresAt.Time.Before(time.Now()) {
+		return loc.T("devices.expired"), false
+	}
+	if user.SuspendedAt.Valid {
+		return loc.T("sharing.suspended"), false
+	}
+	plan, err := b.store.GetUserPlan(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user plan: %v", err)
+		return loc.T("devices.failed"), false
+	}
+	devices, err := b.store.ListDevices(ctx, user.ID)
+	if err != nil {
+		log.Printf("list devices: %v", err)
+		return loc.T("devices.failed"), false
+	}
+	if len(devices)+1 >= plan.Devices {
+		return loc.N("devices.limit", plan.Devices, i18n.Args{"Plan": html.EscapeString(plan.Title)}), false
+	}
+	return "", true
+}
+
+// handleDeviceName creates a device key named by the message text if the
+// user was asked for a name.
+func (b *Bot) handleDeviceName(ctx context.Context, msg *tgbotapi.Message) bool {
+	b.mu.Lock()
+	_, adding := b.addingDevice[msg.From.ID]
+	b.mu.Unlock()
+	if !adding {
+		return false
+	}
+
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		return false
+	}
+	loc := b.loc(user, msg.From)
+	name := strings.TrimSpace(msg.Text)
+	if name == "" || utf8.RuneCountInString(name) > maxDeviceNameLen {
+		b.reply(msg.Chat.ID, loc.T("devices.bad_name", i18n.Args{"Max": maxDeviceNameLen}))
+		return true
+	}
+	b.cancelDeviceAdd(msg)
+	b.replyHTML(msg.Chat.ID, b.addDevice(ctx, loc, user, name))
+	return true
+}
+
+// addDevice creates a panel client sharing the subscription's expiry and
+// returns the HTML reply.
+func (b *Bot) addDevice(ctx context.Context, loc *i18n.Localizer, user *storage.User, name string) string {
+	unlock, ok, err := b.store.TryLockUser(ctx, user.ID)
+	if err != nil {
+		log.Printf("lock user %d: %v", user.ID, err)
+		return loc.T("devices.failed")
+	}
+	if !ok {
+		return loc.T("key.in_progress")
+	}
+	defer unlock()
+
+	user, err = b.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		return loc.T("devices.failed")
+	}
+	if text, ok := b.canAddDevice(ctx, loc, user); !ok {
+		return text
+	}
+	key, err := b.panel.CreateClient(ctx, provision.ClientSpec{
+		UserID: user.ID,
+		Email:  fmt.Sprintf("user-%d-dev-%d@example.com", user.ID, time.Now().Unix()),
+		Expiry: user.ExpiresAt.Time,
+		Server: b.userServer(ctx, user),
+	})
+	if err != nil {
+		log.Printf("panel add device client: %v", err)
+		return b.panelFailed(loc, err, "devices.failed")
+	}
+	if _, err := b.store.AddDevice(ctx, user.ID, name, key); err != nil {
+		log.Printf("add device: %v", err)
+		if err := b.panel.DeleteClient(ctx, key); err != nil {
+			log.Printf("panel delete client %s: %v", key, err)
+		}
+		return loc.T("devices.failed")
+	}
+	b.sendKeyConfig(ctx, loc, user.TelegramID, key)
+	return loc.T("devices.added", i18n.Args{"Name": html.EscapeString(name), "Key": html.EscapeString(b.keyLink(ctx, key)), "Expires": loc.Date(user.ExpiresAt.Time)})
+}
+
+func (b *Bot) cancelDeviceAdd(msg *tgbotapi.Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.addingDevice[msg.From.ID]; !ok {
+		return false
+	}
+	delete(b.addingDevice, msg.From.ID)
+	return true
+}
+
+// extendDevices moves the expiry of the user's additional device keys.
+func (b *Bot) extendDevices(ctx context.Context, user *storage.User, expires time.Time) error {
+	devices, err := b.store.ListDevices(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, d := range devices {
+		if err := b.extendKey(ctx, user, d.KeyID, expires); err != nil {
+			log.Printf("panel update device %d: %v", d.ID, err)
+			failed = append(failed, strconv.Itoa(d.ID))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("devices %s not renewed", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// handleSetPlan implements /setplan <user id> <plan code>.
+func (b *Bot) handleSetPlan(ctx context.Context, msg *tgbotapi.Message) {
+	loc := b.fromLoc(msg.From)
+	fields := strings.Fields(msg.CommandArguments())
+	var userID int
+	var err error
+	if len(fields) == 2 {
+		userID, err = strconv.Atoi(fields[0])
+	}
+	if len(fields) != 2 || err != nil {
+		b.reply(msg.Chat.ID, b.plansUsage(ctx, loc))
+		return
+	}
+	ok, err := b.store.SetUserPlan(ctx, userID, fields[1])
+	if err != nil {
+		log.Printf("set user plan: %v", err)
+		b.reply(msg.Chat.ID, loc.T("admin.plans.failed"))
+		return
+	}
+	if !ok {
+		b.reply(msg.Chat.ID, b.plansUsage(ctx, loc))
+		return
+	}
+	b.reply(msg.Chat.ID, loc.T("admin.plans.set", i18n.Args{"ID": userID, "Plan": fields[1]}))
+}
+
+func (b *Bot) plansUsage(ctx context.Context, loc *i18n.Localizer) string {
+	plans, err := b.store.ListPlans(ctx)
+	if err != nil {
+		log.Printf("list plans: %v", err)
+	}
+	var sb strings.Builder
+	sb.WriteString(loc.T("admin.plans.usage"))
+	for _, p := range plans {
+		fmt.Fprintf(&sb, "\n%s — %s, %s, %s", p.Code, p.Title, loc.N("admin.plans.days", p.Days), loc.N("admin.plans.devices", p.Devices))
+	}
+	return sb.String()
+}
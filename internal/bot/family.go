@@ -0,0 +1,336 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+const (
+	familyPrefix    = "family_"
+	familyInviteTTL = 7 * 24 * time.Hour
+)
+
+func (b *Bot) handleFamily(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
+		return
+	}
+	loc := b.loc(user, msg.From)
+	text, markup := b.familyScreen(ctx, loc, user)
+	b.send(outbox.Message{ChatID: msg.Chat.ID, Text: text, ParseMode: tgbotapi.ModeHTML, Markup: &markup})
+}
+
+// familyScreen lists the owner's family members with buttons to remove them
+// and to invite another one within the plan limit.
+func (b *Bot) familyScreen(ctx context.Context, loc *i18n.Localizer, user *storage.User) (string, tgbotapi.InlineKeyboardMarkup) {
+	back := backMarkup(loc)
+	if user.FamilyOwnerID.Valid {
+		owner, err := b.store.GetUserByID(ctx, int(user.FamilyOwnerID.Int64))
+		if err != nil {
+			log.Printf("get family owner: %v", err)
+			return loc.T("family.failed"), back
+		}
+		return loc.T("family.member", i18n.Args{"Owner": html.EscapeString(owner.Username.String)}), back
+	}
+	plan, err := b.store.GetUserPlan(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user plan: %v", err)
+		return loc.T("family.failed"), back
+	}
+	if plan.FamilyMembers == 0 {
+		return loc.T("family.unavailable", i18n.Args{"Plan": html.EscapeString(plan.Title)}), back
+	}
+	members, err := b.store.ListFamilyMembers(ctx, user.ID)
+	if err != nil {
+		log.Printf("list family members: %v", err)
+		return loc.T("family.failed"), back
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, m := range members {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"🗑 @"+m.Username.String, callbackData("family", "remove", m.ID))))
+	}
+	if len(members) < plan.FamilyMembers {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("family.invite_button"), callbackData("family", "invite"))))
+	}
+	rows = append(rows, back.InlineKeyboard...)
+
+	text := loc.N("family.title", plan.FamilyMembers, i18n.Args{"Plan": html.EscapeString(plan.Title), "Used": len(members)})
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (b *Bot) handleFamilyCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, args []string) {
+	user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil || user == nil {
+		b.editMenu(callback, b.fromLoc(callback.From).T("common.start_first"), nil)
+		return
+	}
+	loc := b.loc(user, callback.From)
+	if len(args) == 0 {
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		b.showFamily(ctx, callback, loc, user)
+	case "invite":
+		b.editMenu(callback, b.inviteFamilyMember(ctx, loc, user), familyBackMarkup(loc))
+	case "remove", "delete":
+		if len(args) != 2 {
+			return
+		}
+		memberID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return
+		}
+		member, err := b.store.GetUserByID(ctx, memberID)
+		if err != nil || !member.FamilyOwnerID.Valid || int(member.FamilyOwnerID.Int64) != user.ID {
+			b.showFamily(ctx, callback, loc, user)
+			return
+		}
+		if args[0] == "remove" {
+			markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(loc.T("family.remove_button"), callbackData("family", "delete", member.ID)),
+				tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("family", "list")),
+			))
+			b.editMenu(callback, loc.T("family.confirm_remove", i18n.Args{"Member": html.EscapeString(member.Username.String)}), &markup)
+			return
+		}
+		b.removeFamilyMember(ctx, user, member)
+		b.showFamily(ctx, callback, loc, user)
+	}
+}
+
+func (b *Bot) showFamily(ctx context.Context, callback *tgbotapi.CallbackQuery, loc *i18n.Localizer, user *storage.User) {
+	text, markup := b.familyScreen(ctx, loc, user)
+	b.editMenu(callback, text, &markup)
+}
+
+func familyBackMarkup(loc *i18n.Localizer) *tgbotapi.InlineKeyboardMarkup {
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("family", "list"))))
+	return &markup
+}
+
+// inviteFamilyMember creates a single-use invite link and returns the HTML
+// reply.
+func (b *Bot) inviteFamilyMember(ctx context.Context, loc *i18n.Localizer, user *storage.User) string {
+	if text, ok := b.canGrowFamily(ctx, loc, user); !ok {
+		return text
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("family invite code: %v", err)
+		return loc.T("family.failed")
+	}
+	code := hex.EncodeToString(buf)
+	expires := time.Now().Add(familyInviteTTL)
+	if err := b.store.CreateFamilyInvite(ctx, user.ID, code, expires); err != nil {
+		log.Printf("create family invite: %v", err)
+		return loc.T("family.failed")
+	}
+	link := fmt.Sprintf("https://t.me/%s?start=%s%s", b.api.Self.UserName, familyPrefix, code)
+	return loc.T("family.invite", i18n.Args{"Link": link, "Expires": loc.Date(expires)})
+}
+
+// canGrowFamily checks that the owner has an active subscription and a free
+// family slot. Otherwise it returns the explanation.
+func (b *Bot) canGrowFamily(ctx context.Context, loc *i18n.Localizer, owner *storage.User) (string, bool) {
+	if owner.FamilyOwnerID.Valid {
+		return loc.T("family.not_owner"), false
+	}
+	if !owner.KeyID.Valid || !owner.ExpiresAt.Valid || owner.ExpiresAt.Time.Before(time.Now()) {
+		return loc.T("family.inactive"), false
+	}
+	plan, err := b.store.GetUserPlan(ctx, owner.ID)
+	if err != nil {
+		log.Printf("get user plan: %v", err)
+		return loc.T("family.failed"), false
+	}
+	members, err := b.store.ListFamilyMembers(ctx, owner.ID)
+	if err != nil {
+		log.Printf("list family members: %v", err)
+		return loc.T("family.failed"), false
+	}
+	if len(members) >= plan.FamilyMembers {
+		return loc.N("family.full", plan.FamilyMembers), false
+	}
+	return "", true
+}
+
+// joinFamily handles /start family_<code> and returns the HTML reply for the
+// new member. A member whose own key has expired gets a family key in its
+// place.
+func (b *Bot) joinFamily(ctx context.Context, loc *i18n.Localizer, member *storage.User, code string) string {
+	if hasActiveKey(member) {
+		return loc.T("family.has_key")
+	}
+	ownerID, err := b.store.GetFamilyInviteOwner(ctx, code)
+	if errors.Is(err, storage.ErrInviteInvalid) || ownerID == member.ID {
+		return loc.T("family.bad_invite")
+	}
+	if err != nil {
+		log.Printf("get family invite: %v", err)
+		return loc.T("family.failed")
+	}
+
+	// The member's lock keeps a concurrent issuance from giving them a key
+	// too; the owner's lock serialises joins so the family limit holds.
+	for _, id := range []int{member.ID, ownerID} {
+		unlock, ok, err := b.store.TryLockUser(ctx, id)
+		if err != nil || !ok {
+			if err != nil {
+				log.Printf("lock user %d: %v", id, err)
+			}
+			return loc.T("family.failed")
+		}
+		defer unlock()
+	}
+	member, err = b.store.GetUserByID(ctx, member.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		return loc.T("family.failed")
+	}
+	if hasActiveKey(member) {
+		return loc.T("family.has_key")
+	}
+	if member.SuspendedAt.Valid {
+		return loc.T("sharing.suspended")
+	}
+	if members, err := b.store.ListFamilyMembers(ctx, member.ID); err != nil || len(members) > 0 {
+		if err != nil {
+			log.Printf("list family members: %v", err)
+			return loc.T("family.failed")
+		}
+		return loc.T("family.is_owner")
+	}
+	oldKeys, err := b.userKeys(ctx, member)
+	if err != nil {
+		log.Printf("list user keys: %v", err)
+		return loc.T("family.failed")
+	}
+
+	owner, err := b.store.GetUserByID(ctx, ownerID)
+	if err != nil {
+		log.Printf("get family owner: %v", err)
+		return loc.T("family.failed")
+	}
+	ownerLoc := b.loc(owner, nil)
+	if _, ok := b.canGrowFamily(ctx, ownerLoc, owner); !ok {
+		return loc.T("family.owner_unavailable")
+	}
+
+	expires := owner.ExpiresAt.Time
+	key, err := b.panel.CreateClient(ctx, provision.ClientSpec{
+		UserID: member.ID,
+		Email:  fmt.Sprintf("user-%d-family-%d@example.com", member.ID, owner.ID),
+		Expiry: expires,
+		Server: b.serverOf(owner.KeyID.String),
+	})
+	if err != nil {
+		log.Printf("panel add family client: %v", err)
+		return b.panelFailed(loc, err, "family.failed")
+	}
+	if err := b.store.JoinFamily(ctx, code, member.ID, member.KeyID, key, expires); err != nil {
+		if err := b.panel.DeleteClient(ctx, key); err != nil {
+			log.Printf("panel delete client %s: %v", key, err)
+		}
+		if errors.Is(err, storage.ErrInviteInvalid) {
+			return loc.T("family.bad_invite")
+		}
+		log.Printf("join family: %v", err)
+		return loc.T("family.failed")
+	}
+	for _, old := range oldKeys {
+		if err := b.deleteClient(ctx, old); err != nil {
+			log.Printf("panel delete expired client %s of user %d: %v", old, member.ID, err)
+		}
+	}
+
+	b.reply(owner.TelegramID, ownerLoc.T("family.joined_owner", i18n.Args{"Member": member.Username.String}))
+	b.sendKeyConfig(ctx, loc, member.TelegramID, key)
+	return loc.T("family.joined", i18n.Args{
+		"Owner":   html.EscapeString(owner.Username.String),
+		"Key":     html.EscapeString(b.keyLink(ctx, key)),
+		"Expires": loc.Date(expires),
+	})
+}
+
+// hasActiveKey reports whether the user has a key that has not expired.
+func hasActiveKey(user *storage.User) bool {
+	return user.KeyID.Valid && (!user.ExpiresAt.Valid || user.ExpiresAt.Time.After(time.Now()))
+}
+
+func (b *Bot) removeFamilyMember(ctx context.Context, owner, member *storage.User) {
+	if member.KeyID.Valid {
+		if err := b.deleteClient(ctx, member.KeyID.String); err != nil {
+			log.Printf("panel delete family client %s: %v", member.KeyID.String, err)
+			return
+		}
+	}
+	removed, err := b.store.RemoveFamilyMember(ctx, owner.ID, member.ID)
+	if err != nil {
+		log.Printf("remove family member: %v", err)
+		return
+	}
+	if removed {
+		b.reply(member.TelegramID, b.loc(member, nil).T("family.removed", i18n.Args{"Owner": owner.Username.String}))
+	}
+}
+
+// extendFamily moves the expiry of the owner's family members' keys. It is
+// idempotent, so a failed call can simply be repeated.
+func (b *Bot) extendFamily(ctx context.Context, owner *storage.User, expires time.Time) error {
+	members, err := b.store.ListFamilyMembers(ctx, owner.ID)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, m := range members {
+		if !m.KeyID.Valid {
+			continue
+		}
+		if err := b.extendKey(ctx, &m, m.KeyID.String, expires); err != nil {
+			log.Printf("panel update family member %d: %v", m.ID, err)
+			failed++
+			continue
+		}
+		if err := b.store.UpdateUserKey(ctx, m.ID, m.KeyID.String, expires); err != nil {
+			log.Printf("update user key: %v", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d family members not renewed", failed)
+	}
+	return nil
+}
+
+func (b *Bot) notifyFamilyRenewed(ctx context.Context, owner *storage.User, expires time.Time) {
+	members, err := b.store.ListFamilyMembers(ctx, owner.ID)
+	if err != nil {
+		log.Printf("list family members: %v", err)
+		return
+	}
+	for _, m := range members {
+		loc := b.loc(&m, nil)
+		b.reply(m.TelegramID, loc.T("family.renewed", i18n.Args{"Expires": loc.Date(expires)}))
+	}
+}
@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+const giftPrefix = "gift_"
+
+func (b *Bot) handleGift(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
+		return
+	}
+	b.replyHTML(msg.Chat.ID, b.startGift(ctx, b.loc(user, msg.From), user, msg.From.ID))
+}
+
+func (b *Bot) handleGiftCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, _ string) {
+	user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil || user == nil {
+		b.editMenu(callback, b.fromLoc(callback.From).T("common.start_first"), nil)
+		return
+	}
+	loc := b.loc(user, callback.From)
+	back := backMarkup(loc)
+	b.editMenu(callback, b.startGift(ctx, loc, user, callback.From.ID), &back)
+}
+
+// startGift makes the user's next payment screenshot a gift purchase and
+// returns the HTML instructions.
+func (b *Bot) startGift(ctx context.Context, loc *i18n.Localizer, user *storage.User, telegramID int64) string {
+	plan, err := b.store.GetUserPlan(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user plan: %v", err)
+		return loc.T("gift.failed")
+	}
+	b.mu.Lock()
+	b.buyingGift[telegramID] = struct{}{}
+	b.mu.Unlock()
+	return loc.T("gift.instructions", i18n.Args{"Plan": html.EscapeString(plan.Title), "Days": loc.N("gift.days", plan.Days)})
+}
+
+// takeGiftMode reports whether the user is buying a gift and leaves the mode.
+func (b *Bot) takeGiftMode(telegramID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.buyingGift[telegramID]
+	delete(b.buyingGift, telegramID)
+	return ok
+}
+
+func (b *Bot) cancelGift(msg *tgbotapi.Message) bool {
+	return b.takeGiftMode(msg.From.ID)
+}
+
+// confirmGift turns a confirmed gift payment into a gift code and sends the
+// buyer its link.
+func (b *Bot) confirmGift(ctx context.Context, callback *tgbotapi.CallbackQuery, payment *storage.Payment, buyer *storage.User) {
+	loc := b.loc(buyer, nil)
+	plan, err := b.store.GetUserPlan(ctx, buyer.ID)
+	if err != nil {
+		log.Printf("get user plan: %v", err)
+		b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.confirm_failed"))
+		return
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("gift code: %v", err)
+		b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.confirm_failed"))
+		return
+	}
+	gift, err := b.store.CreateGift(ctx, hex.EncodeToString(buf), buyer.ID, payment.ID, plan.ID, plan.Days)
+	if err != nil {
+		b.paymentNotClaimed(callback, err)
+		return
+	}
+
+	link := fmt.Sprintf("https://t.me/%s?start=%s%s", b.api.Self.UserName, giftPrefix, gift.Code)
+	b.reply(buyer.TelegramID, loc.T("gift.created", i18n.Args{"Link": link, "Days": loc.N("gift.days", gift.Days)}))
+	b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.confirmed_gift"))
+}
+
+// redeemGift adds the gift's days to the user's subscription, issuing a key
+// if they have none, moves them to the gift's plan and returns the HTML
+// reply.
+func (b *Bot) redeemGift(ctx context.Context, loc *i18n.Localizer, user *storage.User, code string) string {
+	if user.FamilyOwnerID.Valid {
+		return loc.T("gift.family_member")
+	}
+	unlock, ok, err := b.store.TryLockUser(ctx, user.ID)
+	if err != nil || !ok {
+		if err != nil {
+			log.Printf("lock user %d: %v", user.ID, err)
+		}
+		return loc.T("gift.failed")
+	}
+	defer unlock()
+
+	gift, err := b.store.RedeemGift(ctx, code, user.ID)
+	if errors.Is(err, storage.ErrGiftInvalid) {
+		return loc.T("gift.invalid")
+	}
+	if err != nil {
+		log.Printf("redeem gift: %v", err)
+		return loc.T("gift.failed")
+	}
+
+	expires, err := b.applyGift(ctx, loc, user, gift.Days)
+	if err != nil {
+		log.Printf("apply gift %s to user %d: %v", gift.Code, user.ID, err)
+		if err := b.store.ReleaseGift(ctx, gift.Code); err != nil {
+			log.Printf("release gift: %v", err)
+		}
+		return b.panelFailed(loc, err, "gift.failed")
+	}
+	// The recipient gets the plan the buyer paid for.
+	if err := b.store.SetUserPlanID(ctx, user.ID, gift.PlanID); err != nil {
+		log.Printf("set plan of user %d: %v", user.ID, err)
+	}
+
+	if buyer, err := b.store.GetUserByID(ctx, gift.BuyerID); err != nil {
+		log.Printf("get gift buyer: %v", err)
+	} else {
+		b.reply(buyer.TelegramID, b.loc(buyer, nil).T("gift.redeemed_buyer", i18n.Args{"Username": user.Username.String}))
+	}
+	text := loc.T("gift.redeemed", i18n.Args{"Days": loc.N("gift.days", gift.Days), "Expires": loc.Date(expires)})
+	if user.SuspendedAt.Valid {
+		text += "\n\n" + loc.T("sharing.suspended")
+	}
+	return text
+}
+
+// applyGift extends the user's subscription by days from its current expiry,
+// or from now if it has lapsed, and returns the new expiry.
+func (b *Bot) applyGift(ctx context.Context, loc *i18n.Localizer, user *storage.User, days int) (time.Time, error) {
+	from := time.Now()
+	if user.ExpiresAt.Valid && user.ExpiresAt.Time.After(from) {
+		from = user.ExpiresAt.Time
+	}
+	expires := from.Add(time.Duration(days) * 24 * time.Hour)
+
+	if !user.KeyID.Valid {
+		key, err := b.panel.CreateClient(ctx, provision.ClientSpec{UserID: user.ID, Expiry: expires, Server: b.userServer(ctx, user)})
+		if err != nil {
+			return time.Time{}, err
+		}
+		stored, err := b.store.IssueUserKey(ctx, user.ID, key, expires, false)
+		if err != nil || !stored {
+			if err := b.panel.DeleteClient(ctx, key); err != nil {
+				log.Printf("panel delete client %s: %v", key, err)
+			}
+			if err == nil {
+				err = errors.New("user already has a key")
+			}
+			return time.Time{}, err
+		}
+		return expires, nil
+	}
+
+	if err := b.extendKey(ctx, user, user.KeyID.String, expires); err != nil {
+		return time.Time{}, err
+	}
+	if err := b.store.UpdateUserKey(ctx, user.ID, user.KeyID.String, expires); err != nil {
+		return time.Time{}, err
+	}
+	b.extendShared(ctx, loc, user, expires)
+	return expires, nil
+}
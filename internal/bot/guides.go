@@ -0,0 +1,249 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+type guideApp struct {
+	name     string
+	download string
+	// importLink builds a deep link that imports the config into the app.
+	// It is nil for apps without one-tap import.
+	importLink func(link string) string
+}
+
+type platform struct {
+	id          string
+	apps        []guideApp
+	screenshots int
+}
+
+func v2rayNGImport(link string) string {
+	return "v2rayng://install-config?url=" + url.QueryEscape(link)
+}
+
+func streisandImport(link string) string {
+	return "streisand://import/" + link
+}
+
+func hiddifyImport(link string) string {
+	return "hiddify://import/" + link
+}
+
+var platforms = []platform{
+	{
+		id: "ios",
+		apps: []guideApp{
+			{name: "Streisand", download: "https://apps.apple.com/app/streisand/id6450534064", importLink: streisandImport},
+			{name: "Hiddify", download: "https://apps.apple.com/app/hiddify-proxy-vpn/id6596777532", importLink: hiddifyImport},
+			{name: "Shadowrocket", download: "https://apps.apple.com/app/shadowrocket/id932747118"},
+		},
+		screenshots: 3,
+	},
+	{
+		id: "android",
+		apps: []guideApp{
+			{name: "v2rayNG", download: "https://play.google.com/store/apps/details?id=com.v2ray.ang", importLink: v2rayNGImport},
+			{name: "Hiddify", download: "https://play.google.com/store/apps/details?id=app.hiddify.com", importLink: hiddifyImport},
+		},
+		screenshots: 3,
+	},
+	{
+		id: "windows",
+		apps: []guideApp{
+			{name: "Hiddify", download: "https://github.com/hiddify/hiddify-app/releases/latest", importLink: hiddifyImport},
+			{name: "v2rayN", download: "https://github.com/2dust/v2rayN/releases/latest"},
+		},
+		screenshots: 3,
+	},
+	{
+		id: "macos",
+		apps: []guideApp{
+			{name: "Streisand", download: "https://apps.apple.com/app/streisand/id6450534064", importLink: streisandImport},
+			{name: "Hiddify", download: "https://github.com/hiddify/hiddify-app/releases/latest", importLink: hiddifyImport},
+		},
+		screenshots: 3,
+	},
+	{
+		id: "linux",
+		apps: []guideApp{
+			{name: "Hiddify", download: "https://github.com/hiddify/hiddify-app/releases/latest", importLink: hiddifyImport},
+		},
+		screenshots: 2,
+	},
+}
+
+func findPlatform(id string) (platform, bool) {
+	for _, p := range platforms {
+		if p.id == id {
+			return p, true
+		}
+	}
+	return platform{}, false
+}
+
+func platformsMarkup(loc *i18n.Localizer) tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, p := range platforms {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(loc.T("guide.platform."+p.id), callbackData("guide", p.id)))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("menu", screenMain)),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (b *Bot) handleHelp(ctx context.Context, msg *tgbotapi.Message) {
+	loc := b.userLoc(ctx, msg.From)
+	markup := platformsMarkup(loc)
+	b.send(outbox.Message{
+		ChatID:    msg.Chat.ID,
+		Text:      b.renderTemplate(loc, "help.text"),
+		ParseMode: tgbotapi.ModeHTML,
+		Markup:    &markup,
+	})
+}
+
+// connectionLink returns what client apps import for the user, as the
+// user's server gives it. It is empty if the user has no key.
+func (b *Bot) connectionLink(ctx context.Context, user *storage.User) (string, error) {
+	if user == nil || !user.KeyID.Valid {
+		return "", nil
+	}
+	return b.panel.ConnectionLink(ctx, user.KeyID.String)
+}
+
+// buttonURL makes a deep link usable in an inline button by routing it
+// through the redirect page. Without one the link cannot be a button.
+func (b *Bot) buttonURL(link string) (string, bool) {
+	if strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://") {
+		return link, true
+	}
+	if b.opts.RedirectURL == "" {
+		return "", false
+	}
+	sep := "?"
+	if strings.Contains(b.opts.RedirectURL, "?") {
+		sep = "&"
+	}
+	return b.opts.RedirectURL + sep + "to=" + url.QueryEscape(link), true
+}
+
+func (b *Bot) handleGuideCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, id string) {
+	user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+	}
+	loc := b.loc(user, callback.From)
+
+	p, ok := findPlatform(id)
+	if !ok {
+		markup := platformsMarkup(loc)
+		b.editMenu(callback, b.renderTemplate(loc, "help.text"), &markup)
+		return
+	}
+
+	text, markup := b.guideScreen(ctx, loc, user, p)
+	b.editMenu(callback, text, &markup)
+	b.sendScreenshots(callback.Message.Chat.ID, loc, p)
+}
+
+func (b *Bot) guideScreen(ctx context.Context, loc *i18n.Localizer, user *storage.User, p platform) (string, tgbotapi.InlineKeyboardMarkup) {
+	var sb strings.Builder
+	sb.WriteString(b.renderTemplate(loc, "guide."+p.id))
+
+	link, err := b.connectionLink(ctx, user)
+	if err != nil && !errors.Is(err, provision.ErrNotSupported) {
+		log.Printf("connection link of %s: %v", user.KeyID.String, err)
+	}
+	hasKey := link != ""
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var manual []string
+	for _, app := range p.apps {
+		row := []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonURL(loc.T("guide.download", i18n.Args{"App": app.name}), app.download),
+		}
+		if hasKey && app.importLink != nil {
+			deepLink := app.importLink(link)
+			if u, ok := b.buttonURL(deepLink); ok {
+				row = append(row, tgbotapi.NewInlineKeyboardButtonURL(loc.T("guide.import", i18n.Args{"App": app.name}), u))
+			} else {
+				manual = append(manual, fmt.Sprintf("%s: <code>%s</code>", html.EscapeString(app.name), html.EscapeString(deepLink)))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	sb.WriteString("\n\n")
+	if hasKey {
+		sb.WriteString(loc.T("guide.link", i18n.Args{"Link": html.EscapeString(link)}))
+		if len(manual) > 0 {
+			sb.WriteString("\n\n")
+			sb.WriteString(loc.T("guide.deep_links"))
+			sb.WriteString("\n")
+			sb.WriteString(strings.Join(manual, "\n"))
+		}
+	} else if errors.Is(err, provision.ErrNotSupported) {
+		sb.WriteString(loc.T("guide.config"))
+	} else if err != nil {
+		sb.WriteString(b.panelFailed(loc, err, "guide.link_failed"))
+	} else {
+		sb.WriteString(loc.T("guide.no_key"))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("menu", screenGuide)),
+	))
+	return sb.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// guideChat is a chat that was sent a platform's screenshots.
+type guideChat struct {
+	chatID   int64
+	platform string
+}
+
+// sendScreenshots sends the platform's screenshots, expected at
+// <GuideMediaURL>/<platform>/<n>.png. Each chat gets them once, so opening
+// the guide again only shows the text.
+func (b *Bot) sendScreenshots(chatID int64, loc *i18n.Localizer, p platform) {
+	if b.opts.GuideMediaURL == "" {
+		return
+	}
+	key := guideChat{chatID: chatID, platform: p.id}
+	b.mu.Lock()
+	_, sent := b.sentGuides[key]
+	b.sentGuides[key] = struct{}{}
+	b.mu.Unlock()
+	if sent {
+		return
+	}
+	for i := 1; i <= p.screenshots; i++ {
+		b.send(outbox.Message{
+			ChatID:      chatID,
+			PhotoFileID: fmt.Sprintf("%s/%s/%d.png", b.opts.GuideMediaURL, p.id, i),
+			Text:        loc.T("guide.screenshot", i18n.Args{"N": i, "Total": p.screenshots}),
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"context"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/storage"
+)
+
+// loc picks the language saved by the user, falling back to the language of
+// their Telegram client. Either argument may be nil.
+func (b *Bot) loc(user *storage.User, from *tgbotapi.User) *i18n.Localizer {
+	if user != nil && user.Language.Valid {
+		return b.i18n.For(user.Language.String)
+	}
+	if from != nil {
+		return b.i18n.For(b.i18n.Match(from.LanguageCode))
+	}
+	return b.defaultLoc()
+}
+
+func (b *Bot) fromLoc(from *tgbotapi.User) *i18n.Localizer {
+	return b.loc(nil, from)
+}
+
+// userLoc looks the user up to honour a saved /language choice.
+func (b *Bot) userLoc(ctx context.Context, from *tgbotapi.User) *i18n.Localizer {
+	user, err := b.store.GetUserByTelegramID(ctx, from.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+	}
+	return b.loc(user, from)
+}
+
+func (b *Bot) defaultLoc() *i18n.Localizer {
+	return b.i18n.For(i18n.DefaultLanguage)
+}
+
+func (b *Bot) handleLanguage(ctx context.Context, msg *tgbotapi.Message) {
+	loc := b.userLoc(ctx, msg.From)
+	var row []tgbotapi.InlineKeyboardButton
+	for _, lang := range b.i18n.Languages() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(b.i18n.Name(lang), callbackData("lang", lang)))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	b.send(outbox.Message{ChatID: msg.Chat.ID, Text: loc.T("language.choose"), Markup: &markup})
+}
+
+func (b *Bot) setLanguage(ctx context.Context, callback *tgbotapi.CallbackQuery, lang string) {
+	user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil || user == nil {
+		b.editCallback(callback, b.fromLoc(callback.From).T("common.start_first"))
+		return
+	}
+	lang = b.i18n.Match(lang)
+	if err := b.store.SetUserLanguage(ctx, user.ID, lang); err != nil {
+		log.Printf("set user language: %v", err)
+		return
+	}
+	loc := b.i18n.For(lang)
+	b.editCallback(callback, loc.T("language.changed", i18n.Args{"Language": b.i18n.Name(lang)}))
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+)
+
+const (
+	screenMain         = "main"
+	screenSubscription = "sub"
+	screenBuy          = "buy"
+	screenKey          = "key"
+	screenDevices      = "devices"
+	screenFamily       = "family"
+	screenGuide        = "guide"
+	screenSupport      = "support"
+	screenReferral     = "ref"
+	screenConnections  = "ips"
+)
+
+func mainMenuMarkup(loc *i18n.Localizer) tgbotapi.InlineKeyboardMarkup {
+	button := func(screen string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.button."+screen), callbackData("menu", screen))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(button(screenSubscription), button(screenBuy)),
+		tgbotapi.NewInlineKeyboardRow(button(screenKey), button(screenDevices)),
+		tgbotapi.NewInlineKeyboardRow(button(screenGuide), button(screenSupport)),
+		tgbotapi.NewInlineKeyboardRow(button(screenFamily), button(screenReferral)),
+	)
+}
+
+func backMarkup(loc *i18n.Localizer) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("menu", screenMain)),
+	))
+}
+
+func (b *Bot) handleMenu(ctx context.Context, msg *tgbotapi.Message) {
+	b.showMenu(msg.Chat.ID, b.userLoc(ctx, msg.From))
+}
+
+// showMenu sends a new main menu message. Further navigation edits it.
+func (b *Bot) showMenu(chatID int64, loc *i18n.Localizer) {
+	markup := mainMenuMarkup(loc)
+	b.send(outbox.Message{ChatID: chatID, Text: loc.T("menu.title"), ParseMode: tgbotapi.ModeHTML, Markup: &markup})
+}
+
+func (b *Bot) handleMenuCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, screen string) {
+	user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+	}
+	loc := b.loc(user, callback.From)
+	if user == nil {
+		b.editMenu(callback, loc.T("common.start_first"), nil)
+		return
+	}
+
+	back := backMarkup(loc)
+	switch screen {
+	case screenMain:
+		markup := mainMenuMarkup(loc)
+		b.editMenu(callback, loc.T("menu.title"), &markup)
+	case screenSubscription:
+		markup := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.button.ips"), callbackData("menu", screenConnections))),
+			back.InlineKeyboard[0],
+		)
+		b.editMenu(callback, b.subscriptionText(ctx, loc, user), &markup)
+	case screenConnections:
+		markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("menu.back"), callbackData("menu", screenSubscription)),
+		))
+		b.editMenu(callback, b.connectionsText(ctx, loc, user), &markup)
+	case screenBuy:
+		markup := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(loc.T("gift.button"), callbackData("gift", "start"))),
+			back.InlineKeyboard[0],
+		)
+		b.editMenu(callback, b.renderTemplate(loc, "menu.buy"), &markup)
+	case screenKey:
+		b.editMenu(callback, b.issueKey(ctx, loc, user), &back)
+	case screenDevices:
+		b.showDevices(ctx, callback, loc, user)
+	case screenFamily:
+		b.showFamily(ctx, callback, loc, user)
+	case screenGuide:
+		markup := platformsMarkup(loc)
+		b.editMenu(callback, b.renderTemplate(loc, "help.text"), &markup)
+	case screenSupport:
+		markup := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(loc.T("support.new_button"), callbackData("ticket", "new"))),
+			back.InlineKeyboard[0],
+		)
+		b.editMenu(callback, b.renderTemplate(loc, "menu.support"), &markup)
+	case screenReferral:
+		b.editMenu(callback, b.referralText(loc), &back)
+	}
+}
+
+// editMenu replaces the text and keyboard of the menu message in place.
+func (b *Bot) editMenu(callback *tgbotapi.CallbackQuery, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	if callback.Message == nil {
+		return
+	}
+	chatID := callback.Message.Chat.ID
+	edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.ReplyMarkup = markup
+	if _, err := b.outbox.Send(context.Background(), chatID, edit); err != nil && !isNotModified(err) {
+		log.Printf("edit menu: %v", err)
+	}
+}
+
+func (b *Bot) referralText(loc *i18n.Localizer) string {
+	return loc.T("menu.referral", i18n.Args{"Link": "https://t.me/" + b.api.Self.UserName})
+}
+
+// isNotModified reports Telegram's error for an edit that changes nothing,
+// which happens when a menu button is pressed twice.
+func isNotModified(err error) bool {
+	return strings.Contains(err.Error(), "message is not modified")
+}
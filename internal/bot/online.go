@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"html"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+// onlineListLimit caps the users listed by /online.
+const onlineListLimit = 50
+
+// handleOnline implements /online: the users connected now.
+func (b *Bot) handleOnline(ctx context.Context, msg *tgbotapi.Message) {
+	loc := b.fromLoc(msg.From)
+	monitor, ok := b.panel.(provision.Monitor)
+	if !ok {
+		b.reply(msg.Chat.ID, loc.T("admin.online.unsupported"))
+		return
+	}
+	ids, err := monitor.OnlineClients(ctx)
+	if err != nil {
+		log.Printf("online clients: %v", err)
+		b.reply(msg.Chat.ID, loc.T("admin.online.failed", i18n.Args{"Error": err.Error()}))
+		return
+	}
+	if len(ids) == 0 {
+		b.reply(msg.Chat.ID, loc.T("admin.online.none"))
+		return
+	}
+	keys, err := b.store.ListKeys(ctx)
+	if err != nil {
+		log.Printf("list keys: %v", err)
+		b.reply(msg.Chat.ID, loc.T("admin.online.failed", i18n.Args{"Error": err.Error()}))
+		return
+	}
+	byKey := make(map[string]storage.KeyRecord, len(keys))
+	for _, k := range keys {
+		byKey[k.KeyID] = k
+	}
+
+	var sb strings.Builder
+	sb.WriteString(loc.N("admin.online.title", len(ids)))
+	unknown := 0
+	listed := 0
+	for _, id := range ids {
+		k, ok := byKey[id]
+		if !ok {
+			unknown++
+			continue
+		}
+		if listed == onlineListLimit {
+			continue
+		}
+		listed++
+		sb.WriteString("\n")
+		sb.WriteString(b.onlineLine(ctx, loc, k))
+	}
+	if more := len(ids) - unknown - listed; more > 0 {
+		sb.WriteString("\n" + loc.T("admin.online.more", i18n.Args{"Count": more}))
+	}
+	if unknown > 0 {
+		sb.WriteString("\n" + loc.T("admin.online.unknown", i18n.Args{"Count": unknown}))
+	}
+	b.reply(msg.Chat.ID, sb.String())
+}
+
+func (b *Bot) onlineLine(ctx context.Context, loc *i18n.Localizer, k storage.KeyRecord) string {
+	name := ""
+	if user, err := b.store.GetUserByID(ctx, k.UserID); err == nil && user.Username.Valid {
+		name = "@" + user.Username.String
+	}
+	args := i18n.Args{"User": name, "ID": k.TelegramID, "Device": k.DeviceID}
+	if k.DeviceID != 0 {
+		return loc.T("admin.online.device", args)
+	}
+	return loc.T("admin.online.user", args)
+}
+
+// connectionsText lists the addresses the user's keys recently connected
+// from. It returns HTML.
+func (b *Bot) connectionsText(ctx context.Context, loc *i18n.Localizer, user *storage.User) string {
+	if !user.KeyID.Valid {
+		return loc.T("status.no_key")
+	}
+	monitor, ok := b.panel.(provision.Monitor)
+	if !ok {
+		return loc.T("ips.unsupported")
+	}
+	devices, err := b.store.ListDevices(ctx, user.ID)
+	if err != nil {
+		log.Printf("list devices: %v", err)
+		return loc.T("ips.failed")
+	}
+
+	type key struct{ name, id string }
+	keys := []key{{loc.T("ips.main_key"), user.KeyID.String}}
+	for _, d := range devices {
+		keys = append(keys, key{d.Name, d.KeyID})
+	}
+
+	var sb strings.Builder
+	sb.WriteString(loc.T("ips.title"))
+	for _, k := range keys {
+		ips, err := monitor.ClientIPs(ctx, k.id)
+		if errors.Is(err, provision.ErrNotSupported) {
+			continue
+		}
+		if err != nil {
+			log.Printf("client IPs of %s: %v", k.id, err)
+			return b.panelFailed(loc, err, "ips.failed")
+		}
+		sb.WriteString("\n\n<b>" + html.EscapeString(k.name) + "</b>")
+		if len(ips) == 0 {
+			sb.WriteString("\n" + loc.T("ips.none"))
+		}
+		for _, ip := range ips {
+			sb.WriteString("\n• <code>" + html.EscapeString(ip.IP) + "</code>")
+			if !ip.SeenAt.IsZero() {
+				sb.WriteString(" — " + loc.Date(ip.SeenAt) + " " + ip.SeenAt.Format("15:04"))
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/reconcile"
+	"vpn-bot/internal/storage"
+)
+
+// reconcileListLimit caps the entries listed per category in a report.
+const reconcileListLimit = 10
+
+// handleReconcile implements /reconcile [fix]. Without "fix" it only
+// reports.
+func (b *Bot) handleReconcile(ctx context.Context, msg *tgbotapi.Message) {
+	loc := b.fromLoc(msg.From)
+	if b.opts.Reconciler == nil {
+		b.reply(msg.Chat.ID, loc.T("admin.reconcile.disabled"))
+		return
+	}
+	repair := strings.TrimSpace(msg.CommandArguments()) == "fix"
+	if repair && b.opts.Reconciler.Source() == reconcile.SourceNone {
+		b.reply(msg.Chat.ID, loc.T("admin.reconcile.no_source"))
+		return
+	}
+	b.reply(msg.Chat.ID, loc.T("admin.reconcile.started"))
+
+	go func() {
+		report, err := b.opts.Reconciler.Run(ctx, repair)
+		if err != nil {
+			log.Printf("reconcile: %v", err)
+			b.reply(msg.Chat.ID, loc.T("admin.reconcile.failed", i18n.Args{"Error": err.Error()}))
+			return
+		}
+		b.reply(msg.Chat.ID, b.reconcileReport(loc, report, repair))
+	}()
+}
+
+// Reconcile runs the scheduled check and reports differences to admins.
+// It never repairs: that takes an admin's /reconcile fix.
+func (b *Bot) Reconcile(ctx context.Context) error {
+	if b.opts.Reconciler == nil {
+		return nil
+	}
+	report, err := b.opts.Reconciler.Run(ctx, false)
+	if err != nil {
+		return err
+	}
+	if report.Clean() {
+		return nil
+	}
+	text := b.reconcileReport(b.defaultLoc(), report, false)
+	for adminID := range b.admins {
+		b.reply(adminID, text)
+	}
+	return nil
+}
+
+func (b *Bot) reconcileReport(loc *i18n.Localizer, report *reconcile.Report, repair bool) string {
+	if report.Clean() {
+		return loc.T("admin.reconcile.clean")
+	}
+	var sb strings.Builder
+	sb.WriteString(loc.T("admin.reconcile.title", i18n.Args{
+		"Orphans":    len(report.Orphans),
+		"Missing":    len(report.Missing),
+		"Mismatched": len(report.Mismatched),
+	}))
+
+	if len(report.Orphans) > 0 {
+		sb.WriteString("\n\n" + loc.T("admin.reconcile.orphans"))
+		for i, c := range report.Orphans {
+			if i == reconcileListLimit {
+				sb.WriteString("\n" + loc.N("admin.reconcile.more", len(report.Orphans)-i))
+				break
+			}
+			fmt.Fprintf(&sb, "\n%s %s", c.ID, c.Email)
+		}
+	}
+	if len(report.Missing) > 0 {
+		sb.WriteString("\n\n" + loc.T("admin.reconcile.missing"))
+		for i, k := range report.Missing {
+			if i == reconcileListLimit {
+				sb.WriteString("\n" + loc.N("admin.reconcile.more", len(report.Missing)-i))
+				break
+			}
+			sb.WriteString("\n" + keyLabel(loc, k))
+		}
+	}
+	if len(report.Mismatched) > 0 {
+		sb.WriteString("\n\n" + loc.T("admin.reconcile.mismatched"))
+		for i, m := range report.Mismatched {
+			if i == reconcileListLimit {
+				sb.WriteString("\n" + loc.N("admin.reconcile.more", len(report.Mismatched)-i))
+				break
+			}
+			db, panelExpiry := loc.T("admin.reconcile.unlimited"), loc.T("admin.reconcile.unlimited")
+			if m.Key.ExpiresAt.Valid {
+				db = loc.Date(m.Key.ExpiresAt.Time)
+			}
+			if !m.PanelExpiry.IsZero() {
+				panelExpiry = loc.Date(m.PanelExpiry)
+			}
+			sb.WriteString("\n" + loc.T("admin.reconcile.mismatch", i18n.Args{"Key": keyLabel(loc, m.Key), "DB": db, "Panel": panelExpiry}))
+		}
+	}
+
+	if len(report.Unreachable) > 0 {
+		sb.WriteString("\n\n" + loc.T("admin.reconcile.unreachable"))
+		names := make([]string, 0, len(report.Unreachable))
+		for name := range report.Unreachable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&sb, "\n%s: %v", name, report.Unreachable[name])
+		}
+	}
+
+	if repair {
+		sb.WriteString("\n\n" + loc.T("admin.reconcile.repaired", i18n.Args{
+			"Source":   string(b.opts.Reconciler.Source()),
+			"Repaired": report.Repaired,
+			"Failed":   report.Failed,
+			"Skipped":  report.Skipped,
+		}))
+	} else if b.opts.Reconciler.Source() != reconcile.SourceNone {
+		sb.WriteString("\n\n" + loc.T("admin.reconcile.fix_hint"))
+	}
+	return sb.String()
+}
+
+func keyLabel(loc *i18n.Localizer, k storage.KeyRecord) string {
+	if k.DeviceID != 0 {
+		return loc.T("admin.reconcile.device_key", i18n.Args{"Key": k.KeyID, "User": k.UserID, "Device": k.DeviceID})
+	}
+	return loc.T("admin.reconcile.user_key", i18n.Args{"Key": k.KeyID, "User": k.UserID})
+}
@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/storage"
+)
+
+const (
+	renewalMaxAttempts = 10
+	renewalMaxBackoff  = time.Hour
+	renewalBatchSize   = 20
+)
+
+// startRenewal confirms the payment together with a durable renewal record
+// and runs it. A payment can start only one renewal, so repeated clicks on
+// the confirm button do not extend twice.
+func (b *Bot) startRenewal(ctx context.Context, callback *tgbotapi.CallbackQuery, payment *storage.Payment, user *storage.User) {
+	plan, err := b.store.GetUserPlan(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user plan: %v", err)
+		b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.confirm_failed"))
+		return
+	}
+	expires := time.Now().Add(time.Duration(plan.Days) * 24 * time.Hour)
+	renewal, err := b.store.StartRenewal(ctx, payment.ID, user.ID, expires)
+	if err != nil {
+		b.paymentNotClaimed(callback, err)
+		return
+	}
+	b.editCallback(callback, b.fromLoc(callback.From).T("admin.payment.confirmed"))
+	b.runRenewal(ctx, renewal)
+}
+
+// RetryRenewals runs the due steps of unfinished renewals, including those
+// interrupted by a restart.
+func (b *Bot) RetryRenewals(ctx context.Context) error {
+	renewals, err := b.store.ListDueRenewals(ctx, renewalBatchSize)
+	if err != nil {
+		log.Printf("list due renewals: %v", err)
+		return err
+	}
+	for i := range renewals {
+		b.runRenewal(ctx, &renewals[i])
+	}
+	return nil
+}
+
+// runRenewal advances the renewal under the user's lock and schedules a
+// retry if a step fails.
+func (b *Bot) runRenewal(ctx context.Context, r *storage.Renewal) {
+	unlock, ok, err := b.store.TryLockUser(ctx, r.UserID)
+	if err != nil || !ok {
+		// Another worker holds the user; the renewal stays due.
+		if err != nil {
+			log.Printf("lock user %d: %v", r.UserID, err)
+		}
+		return
+	}
+	defer unlock()
+
+	// Re-read under the lock in case another worker has just advanced it.
+	r, err = b.store.GetRenewal(ctx, r.ID)
+	if err != nil {
+		log.Printf("get renewal: %v", err)
+		return
+	}
+	err = b.advanceRenewal(ctx, r)
+	if err == nil {
+		return
+	}
+	log.Printf("renewal %d (payment %d) in state %s: %v", r.ID, r.PaymentID, r.State, err)
+
+	if r.Attempts+1 >= renewalMaxAttempts {
+		if err := b.store.FailRenewal(ctx, r.ID, err.Error()); err != nil {
+			log.Printf("fail renewal: %v", err)
+		}
+		b.renewalFailed(ctx, r, err)
+		return
+	}
+	backoff := time.Minute << uint(r.Attempts)
+	if backoff > renewalMaxBackoff {
+		backoff = renewalMaxBackoff
+	}
+	if err := b.store.RetryRenewal(ctx, r.ID, time.Now().Add(backoff), err.Error()); err != nil {
+		log.Printf("retry renewal: %v", err)
+	}
+}
+
+// advanceRenewal runs the remaining steps. Every step sets absolute values
+// taken from the renewal, so repeating one after a crash is harmless.
+func (b *Bot) advanceRenewal(ctx context.Context, r *storage.Renewal) error {
+	user, err := b.store.GetUserByID(ctx, r.UserID)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+	issued := false
+	if !user.KeyID.Valid {
+		if r.State != storage.RenewalPending {
+			return fmt.Errorf("user %d has no key", user.ID)
+		}
+		// The first payment of a user without a trial key issues one.
+		key, err := b.issueRenewalKey(ctx, user, r)
+		if err != nil {
+			return err
+		}
+		user.KeyID = sql.NullString{String: key, Valid: true}
+		issued = true
+	}
+
+	if r.State == storage.RenewalPending {
+		if err := b.extendKey(ctx, user, user.KeyID.String, r.ExpiresAt); err != nil {
+			return fmt.Errorf("panel update: %w", err)
+		}
+		if err := b.extendDevices(ctx, user, r.ExpiresAt); err != nil {
+			return err
+		}
+		if err := b.extendFamily(ctx, user, r.ExpiresAt); err != nil {
+			return err
+		}
+		if err := b.store.SetRenewalState(ctx, r.ID, storage.RenewalPanelDone); err != nil {
+			return err
+		}
+		r.State = storage.RenewalPanelDone
+	}
+
+	if r.State == storage.RenewalPanelDone {
+		if err := b.store.UpdateUserKey(ctx, user.ID, user.KeyID.String, r.ExpiresAt); err != nil {
+			return fmt.Errorf("update user key: %w", err)
+		}
+		if err := b.store.SetRenewalState(ctx, r.ID, storage.RenewalDone); err != nil {
+			return err
+		}
+		r.State = storage.RenewalDone
+
+		loc := b.loc(user, nil)
+		if issued {
+			b.sendKeyConfig(ctx, loc, user.TelegramID, user.KeyID.String)
+			b.replyHTML(user.TelegramID, b.renderTemplate(loc, "key.issued", i18n.Args{"Key": b.keyLink(ctx, user.KeyID.String), "Expires": loc.Date(r.ExpiresAt)}))
+		} else {
+			b.replyTemplate(user.TelegramID, loc, "payment.confirmed", i18n.Args{"Expires": loc.Date(r.ExpiresAt)})
+		}
+		if user.SuspendedAt.Valid {
+			b.reply(user.TelegramID, loc.T("sharing.suspended"))
+		}
+		b.notifyFamilyRenewed(ctx, user, r.ExpiresAt)
+	}
+	return nil
+}
+
+// issueRenewalKey creates and stores the key paid for by a user who has
+// none. A client left by an interrupted attempt is removed by reconciliation.
+func (b *Bot) issueRenewalKey(ctx context.Context, user *storage.User, r *storage.Renewal) (string, error) {
+	key, err := b.createKey(ctx, user, r.ExpiresAt)
+	if err != nil {
+		return "", fmt.Errorf("panel add client: %w", err)
+	}
+	stored, err := b.store.IssueUserKey(ctx, user.ID, key, r.ExpiresAt, false)
+	if err == nil && !stored {
+		err = fmt.Errorf("user %d already has a key", user.ID)
+	}
+	if err != nil {
+		if err := b.deleteClient(ctx, key); err != nil {
+			log.Printf("panel delete client %s: %v", key, err)
+		}
+		return "", fmt.Errorf("issue user key: %w", err)
+	}
+	return key, nil
+}
+
+func (b *Bot) renewalFailed(ctx context.Context, r *storage.Renewal, err error) {
+	if user, uerr := b.store.GetUserByID(ctx, r.UserID); uerr == nil {
+		b.reply(user.TelegramID, b.loc(user, nil).T("payment.renew_failed"))
+	}
+	text := b.defaultLoc().T("admin.payment.renewal_failed", i18n.Args{"Payment": r.PaymentID, "User": r.UserID, "Error": err.Error()})
+	for adminID := range b.admins {
+		b.reply(adminID, text)
+	}
+}
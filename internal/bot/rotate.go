@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+// handleRotateKey asks for confirmation before regenerating the key, since
+// the current key stops working on every device.
+func (b *Bot) handleRotateKey(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
+		return
+	}
+	loc := b.loc(user, msg.From)
+	if !user.KeyID.Valid {
+		b.reply(msg.Chat.ID, loc.T("status.no_key"))
+		return
+	}
+	if next, ok := b.nextRotation(user); !ok {
+		b.reply(msg.Chat.ID, loc.T("rotate.too_soon", i18n.Args{"Expires": loc.Date(next)}))
+		return
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("rotate.confirm_button"), callbackData("rotate", "confirm")),
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("rotate.cancel_button"), callbackData("rotate", "cancel")),
+	))
+	b.send(outbox.Message{ChatID: msg.Chat.ID, Text: loc.T("rotate.confirm"), Markup: &markup})
+}
+
+// nextRotation returns when the user may rotate their key again and whether
+// that time has already come.
+func (b *Bot) nextRotation(user *storage.User) (time.Time, bool) {
+	if !user.KeyRotatedAt.Valid {
+		return time.Time{}, true
+	}
+	next := user.KeyRotatedAt.Time.Add(b.opts.KeyRotationInterval)
+	return next, !time.Now().Before(next)
+}
+
+func (b *Bot) handleRotateCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, action string) {
+	user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil || user == nil {
+		b.editCallback(callback, b.fromLoc(callback.From).T("common.start_first"))
+		return
+	}
+	loc := b.loc(user, callback.From)
+	if action != "confirm" {
+		b.editCallback(callback, loc.T("rotate.cancelled"))
+		return
+	}
+	b.editMenu(callback, b.rotateKey(ctx, loc, user), nil)
+}
+
+// rotateKey replaces the user's panel client with a new one keeping its
+// expiry and traffic limit, then deletes the old client. It returns the
+// HTML reply.
+func (b *Bot) rotateKey(ctx context.Context, loc *i18n.Localizer, user *storage.User) string {
+	unlock, ok, err := b.store.TryLockUser(ctx, user.ID)
+	if err != nil {
+		log.Printf("lock user %d: %v", user.ID, err)
+		return loc.T("rotate.failed")
+	}
+	if !ok {
+		return loc.T("key.in_progress")
+	}
+	defer unlock()
+
+	// Re-read under the lock: another rotation may have just finished.
+	user, err = b.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		return loc.T("rotate.failed")
+	}
+	if !user.KeyID.Valid {
+		return loc.T("status.no_key")
+	}
+	if user.SuspendedAt.Valid {
+		return loc.T("sharing.suspended")
+	}
+	if next, ok := b.nextRotation(user); !ok {
+		return loc.T("rotate.too_soon", i18n.Args{"Expires": loc.Date(next)})
+	}
+	oldKey := user.KeyID.String
+
+	info, err := b.panel.GetClient(ctx, oldKey)
+	if err != nil {
+		log.Printf("panel get client %s: %v", oldKey, err)
+		return b.panelFailed(loc, err, "rotate.failed")
+	}
+	// The new key carries over the limits and the traffic left, so
+	// rotating does not reset the usage.
+	total := info.TotalBytes
+	if total > 0 {
+		total -= info.Up + info.Down
+		if total < 1 {
+			// Zero would mean unlimited.
+			total = 1
+		}
+	}
+	var limitIP int
+	if l, ok := b.panel.(provision.IPLimiter); ok {
+		limitIP, err = l.ClientLimitIP(ctx, oldKey)
+		if err != nil {
+			log.Printf("panel IP limit of %s: %v", oldKey, err)
+			return b.panelFailed(loc, err, "rotate.failed")
+		}
+	}
+	newKey, err := b.panel.CreateClient(ctx, provision.ClientSpec{
+		UserID:     user.ID,
+		Email:      fmt.Sprintf("user-%d-%d@example.com", user.ID, time.Now().Unix()),
+		LimitIP:    limitIP,
+		TotalBytes: total,
+		Expiry:     info.Expiry,
+		NoExpiry:   info.Expiry.IsZero(),
+		Server:     b.serverOf(oldKey),
+	})
+	if err != nil {
+		log.Printf("panel create client: %v", err)
+		return b.panelFailed(loc, err, "rotate.failed")
+	}
+
+	expires := sql.NullTime{Time: info.Expiry, Valid: !info.Expiry.IsZero()}
+	rotated, err := b.store.RotateUserKey(ctx, user.ID, oldKey, newKey, expires)
+	if err != nil || !rotated {
+		// Another rotation won or the write failed: drop the client we made
+		// so it is not orphaned.
+		if err != nil {
+			log.Printf("rotate user key: %v", err)
+		}
+		if err := b.panel.DeleteClient(ctx, newKey); err != nil {
+			log.Printf("panel delete client %s: %v", newKey, err)
+		}
+		return loc.T("rotate.failed")
+	}
+
+	if err := b.deleteClient(ctx, oldKey); err != nil {
+		log.Printf("panel delete old client %s of user %d: %v", oldKey, user.ID, err)
+	}
+	b.sendKeyConfig(ctx, loc, user.TelegramID, newKey)
+	if !expires.Valid {
+		return b.renderTemplate(loc, "rotate.done_unlimited", i18n.Args{"Key": b.keyLink(ctx, newKey)})
+	}
+	return b.renderTemplate(loc, "rotate.done", i18n.Args{"Key": b.keyLink(ctx, newKey), "Expires": loc.Date(info.Expiry)})
+}
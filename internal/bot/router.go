@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// callbackHandler handles a callback query. args are the colon-separated
+// parts of the callback data that follow the route name.
+type callbackHandler func(ctx context.Context, callback *tgbotapi.CallbackQuery, args []string)
+
+type route struct {
+	handler callbackHandler
+	admin   bool
+}
+
+// router dispatches callback queries by the route name prefix of their data.
+type router struct {
+	routes map[string]route
+}
+
+func newRouter() *router {
+	return &router{routes: make(map[string]route)}
+}
+
+func (r *router) handle(name string, h callbackHandler) {
+	r.routes[name] = route{handler: h}
+}
+
+// handleAdmin registers a route that ignores callbacks from non-admins.
+func (r *router) handleAdmin(name string, h callbackHandler) {
+	r.routes[name] = route{handler: h, admin: true}
+}
+
+// match returns the route for data and the arguments following its name.
+func (r *router) match(data string) (route, []string, bool) {
+	parts := strings.Split(data, ":")
+	rt, ok := r.routes[parts[0]]
+	return rt, parts[1:], ok
+}
+
+// callbackData encodes a route name and its arguments. Telegram limits
+// callback data to 64 bytes.
+func callbackData(name string, args ...interface{}) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, a := range args {
+		sb.WriteByte(':')
+		fmt.Fprint(&sb, a)
+	}
+	return sb.String()
+}
+
+// withArg adapts a handler taking a single string argument.
+func withArg(h func(ctx context.Context, callback *tgbotapi.CallbackQuery, arg string)) callbackHandler {
+	return func(ctx context.Context, callback *tgbotapi.CallbackQuery, args []string) {
+		if len(args) != 1 {
+			return
+		}
+		h(ctx, callback, args[0])
+	}
+}
+
+// withID adapts a handler taking a single numeric argument.
+func withID(h func(ctx context.Context, callback *tgbotapi.CallbackQuery, id int)) callbackHandler {
+	return withArg(func(ctx context.Context, callback *tgbotapi.CallbackQuery, arg string) {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return
+		}
+		h(ctx, callback, id)
+	})
+}
+
+func (b *Bot) registerCallbacks() {
+	r := newRouter()
+	r.handle("menu", withArg(b.handleMenuCallback))
+	r.handle("lang", withArg(b.setLanguage))
+	r.handle("guide", withArg(b.handleGuideCallback))
+	r.handle("ticket", b.handleTicketCallback)
+	r.handle("rotate", withArg(b.handleRotateCallback))
+	r.handle("devices", b.handleDevicesCallback)
+	r.handle("family", b.handleFamilyCallback)
+	r.handle("gift", withArg(b.handleGiftCallback))
+	r.handleAdmin("confirm", withID(b.confirmPayment))
+	r.handleAdmin("reject", withID(func(_ context.Context, callback *tgbotapi.CallbackQuery, id int) {
+		b.requestRejectReason(callback, id)
+	}))
+	r.handleAdmin("bcast", withArg(b.handleBroadcastCallback))
+	b.callbacks = r
+}
+
+func (b *Bot) handleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	rt, args, ok := b.callbacks.match(callback.Data)
+	if ok && (!rt.admin || b.isAdmin(callback.From.ID)) {
+		rt.handler(ctx, callback, args)
+	}
+	_, _ = b.api.Request(tgbotapi.NewCallback(callback.ID, ""))
+}
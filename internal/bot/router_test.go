@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRouter(t *testing.T) {
+	var got []string
+	record := func(name string) callbackHandler {
+		return func(_ context.Context, _ *tgbotapi.CallbackQuery, args []string) {
+			got = append([]string{name}, args...)
+		}
+	}
+	r := newRouter()
+	r.handle("menu", record("menu"))
+	r.handleAdmin("confirm", record("confirm"))
+
+	tests := []struct {
+		data  string
+		ok    bool
+		admin bool
+		want  []string
+	}{
+		{callbackData("menu", "status"), true, false, []string{"menu", "status"}},
+		{callbackData("menu"), true, false, []string{"menu"}},
+		{callbackData("confirm", 42), true, true, []string{"confirm", "42"}},
+		{callbackData("menu", "devices", 3), true, false, []string{"menu", "devices", "3"}},
+		{"unknown:1", false, false, nil},
+		{"", false, false, nil},
+	}
+	for _, tt := range tests {
+		got = nil
+		rt, args, ok := r.match(tt.data)
+		if ok != tt.ok {
+			t.Errorf("match(%q) ok = %v, want %v", tt.data, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if rt.admin != tt.admin {
+			t.Errorf("match(%q) admin = %v, want %v", tt.data, rt.admin, tt.admin)
+		}
+		rt.handler(context.Background(), nil, args)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("match(%q) handled %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestWithID(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"7"}, 7},
+		{[]string{"x"}, 0},
+		{[]string{}, 0},
+		{[]string{"1", "2"}, 0},
+	}
+	for _, tt := range tests {
+		got := 0
+		h := withID(func(_ context.Context, _ *tgbotapi.CallbackQuery, id int) { got = id })
+		h(context.Background(), nil, tt.args)
+		if got != tt.want {
+			t.Errorf("withID handler(%v) got %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/sharing"
+	"vpn-bot/internal/storage"
+)
+
+// sharingEvidenceLimit caps the samples listed in a sharing alert.
+const sharingEvidenceLimit = 15
+
+// CheckSharing samples connected addresses and reports users whose keys
+// are used from more networks than their plan allows, warning or suspending
+// them if configured.
+func (b *Bot) CheckSharing(ctx context.Context) error {
+	d := b.opts.Sharing
+	if d == nil {
+		return nil
+	}
+	if _, err := d.Sample(ctx); err != nil {
+		log.Printf("sample connections: %v", err)
+	}
+	suspects, err := d.Suspects(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range suspects {
+		action := d.Action()
+		user, err := b.store.GetUserByID(ctx, s.UserID)
+		if err != nil {
+			log.Printf("get user %d: %v", s.UserID, err)
+			continue
+		}
+		switch action {
+		case sharing.ActionWarn:
+			loc := b.loc(user, nil)
+			b.reply(user.TelegramID, loc.T("sharing.warning", i18n.Args{"Devices": s.Devices}))
+		case sharing.ActionSuspend:
+			if err := b.suspendKeys(ctx, user); err != nil {
+				log.Printf("suspend user %d: %v", user.ID, err)
+				action = sharing.ActionNone
+			} else {
+				b.reply(user.TelegramID, b.loc(user, nil).T("sharing.suspended"))
+			}
+		}
+		if err := b.store.FlagSharing(ctx, user.ID, s.Networks, string(action)); err != nil {
+			log.Printf("flag sharing: %v", err)
+		}
+		text := b.sharingReport(b.defaultLoc(), user, s, action)
+		for adminID := range b.admins {
+			b.reply(adminID, text)
+		}
+	}
+	return nil
+}
+
+// suspendKeys disables the user's main and device keys on the panel and
+// records the suspension, which only /unsuspend lifts. If a key
+// cannot be disabled, the others are enabled again.
+func (b *Bot) suspendKeys(ctx context.Context, user *storage.User) error {
+	keys, err := b.userKeys(ctx, user)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.panel.DisableClient(ctx, key); err != nil {
+			if rerr := b.enableKeys(ctx, user, keys); rerr != nil {
+				log.Printf("re-enable keys of user %d: %v", user.ID, rerr)
+			}
+			return fmt.Errorf("disable %s: %w", key, err)
+		}
+	}
+	return b.store.SuspendUser(ctx, user.ID)
+}
+
+// enableKeys enables the keys again, keeping the user's expiry.
+func (b *Bot) enableKeys(ctx context.Context, user *storage.User, keys []string) error {
+	var expires time.Time
+	if user.ExpiresAt.Valid {
+		expires = user.ExpiresAt.Time
+	}
+	for _, key := range keys {
+		if err := b.panel.SetClientExpiry(ctx, key, expires); err != nil {
+			return fmt.Errorf("enable %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// extendKey sets the expiry of one of the user's keys. Setting the expiry
+// enables the key, so a suspended user's key is disabled again: paying does
+// not lift a suspension.
+func (b *Bot) extendKey(ctx context.Context, user *storage.User, key string, expires time.Time) error {
+	if err := b.panel.SetClientExpiry(ctx, key, expires); err != nil {
+		return err
+	}
+	if user.SuspendedAt.Valid {
+		return b.panel.DisableClient(ctx, key)
+	}
+	return nil
+}
+
+// userKeys returns the user's main and device keys.
+func (b *Bot) userKeys(ctx context.Context, user *storage.User) ([]string, error) {
+	var keys []string
+	if user.KeyID.Valid {
+		keys = append(keys, user.KeyID.String)
+	}
+	devices, err := b.store.ListDevices(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		keys = append(keys, d.KeyID)
+	}
+	return keys, nil
+}
+
+// handleUnsuspend implements /unsuspend <telegram id>: it enables the keys
+// of a user suspended for sharing.
+func (b *Bot) handleUnsuspend(ctx context.Context, msg *tgbotapi.Message) {
+	loc := b.fromLoc(msg.From)
+	telegramID, err := strconv.ParseInt(strings.TrimSpace(msg.CommandArguments()), 10, 64)
+	if err != nil {
+		b.reply(msg.Chat.ID, loc.T("admin.unsuspend.usage"))
+		return
+	}
+	user, err := b.store.GetUserByTelegramID(ctx, telegramID)
+	if err != nil || user == nil {
+		if err != nil {
+			log.Printf("get user: %v", err)
+		}
+		b.reply(msg.Chat.ID, loc.T("admin.unsuspend.not_found"))
+		return
+	}
+
+	unlock, ok, err := b.store.TryLockUser(ctx, user.ID)
+	if err != nil || !ok {
+		if err != nil {
+			log.Printf("lock user %d: %v", user.ID, err)
+		}
+		b.reply(msg.Chat.ID, loc.T("admin.unsuspend.failed"))
+		return
+	}
+	defer unlock()
+
+	user, err = b.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		b.reply(msg.Chat.ID, loc.T("admin.unsuspend.failed"))
+		return
+	}
+	if !user.SuspendedAt.Valid {
+		b.reply(msg.Chat.ID, loc.T("admin.unsuspend.not_suspended"))
+		return
+	}
+	keys, err := b.userKeys(ctx, user)
+	if err == nil {
+		err = b.enableKeys(ctx, user, keys)
+	}
+	if err == nil {
+		_, err = b.store.UnsuspendUser(ctx, user.ID)
+	}
+	if err != nil {
+		log.Printf("unsuspend user %d: %v", user.ID, err)
+		b.reply(msg.Chat.ID, b.panelFailed(loc, err, "admin.unsuspend.failed"))
+		return
+	}
+	b.reply(user.TelegramID, b.loc(user, nil).T("sharing.unsuspended"))
+	b.reply(msg.Chat.ID, loc.T("admin.unsuspend.done", i18n.Args{"User": telegramID}))
+}
+
+func (b *Bot) sharingReport(loc *i18n.Localizer, user *storage.User, s sharing.Suspect, action sharing.Action) string {
+	name := fmt.Sprint(user.TelegramID)
+	if user.Username.Valid {
+		name = "@" + user.Username.String + " (" + name + ")"
+	}
+	var sb strings.Builder
+	sb.WriteString(loc.T("admin.sharing.title", i18n.Args{
+		"User":     name,
+		"Devices":  s.Devices,
+		"Networks": s.Networks,
+		"Window":   shortDuration(b.opts.Sharing.Window()),
+	}))
+	for i, sample := range s.Samples {
+		if i == sharingEvidenceLimit {
+			sb.WriteString("\n" + loc.T("admin.sharing.more", i18n.Args{"Count": len(s.Samples) - i}))
+			break
+		}
+		line := fmt.Sprintf("\n• %s (%s", sample.IP, sample.Network)
+		if sample.ASN != 0 {
+			line += fmt.Sprintf(", AS%d", sample.ASN)
+		}
+		line += fmt.Sprintf(") %s, %s %s", sample.KeyID, loc.Date(sample.LastSeenAt), sample.LastSeenAt.Format("15:04"))
+		sb.WriteString(line)
+	}
+	sb.WriteString("\n" + loc.T("admin.sharing.action."+string(action), i18n.Args{"User": user.TelegramID}))
+	return sb.String()
+}
+
+// shortDuration formats d without trailing zero units, as in "24h".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
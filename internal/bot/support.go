@@ -0,0 +1,391 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/storage"
+)
+
+// supportTarget is a chat, optionally a forum topic, receiving tickets.
+type supportTarget struct {
+	chatID   int64
+	threadID int
+}
+
+// supportTargets returns the support chat if configured, otherwise the
+// private chats of all admins.
+func (b *Bot) supportTargets() []supportTarget {
+	if b.opts.SupportChatID != 0 {
+		return []supportTarget{{chatID: b.opts.SupportChatID, threadID: b.opts.SupportThreadID}}
+	}
+	targets := make([]supportTarget, 0, len(b.admins))
+	for id := range b.admins {
+		targets = append(targets, supportTarget{chatID: id})
+	}
+	return targets
+}
+
+func (b *Bot) supportRequest(ctx context.Context, t supportTarget, endpoint string, params tgbotapi.Params) (tgbotapi.Message, error) {
+	params.AddNonZero64("chat_id", t.chatID)
+	params.AddNonZero("message_thread_id", t.threadID)
+	resp, err := b.outbox.Request(ctx, t.chatID, endpoint, params)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+	var m tgbotapi.Message
+	err = json.Unmarshal(resp.Result, &m)
+	return m, err
+}
+
+// postToSupport sends text to every support target and links the posted
+// messages to the ticket so admin replies can be routed back.
+func (b *Bot) postToSupport(ctx context.Context, ticketID int, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	for _, t := range b.supportTargets() {
+		params := tgbotapi.Params{}
+		params.AddNonEmpty("text", text)
+		if markup != nil {
+			if err := params.AddInterface("reply_markup", markup); err != nil {
+				log.Printf("support markup: %v", err)
+			}
+		}
+		m, err := b.supportRequest(ctx, t, "sendMessage", params)
+		if err != nil {
+			log.Printf("post to support chat %d: %v", t.chatID, err)
+			continue
+		}
+		if err := b.store.LinkSupportMessage(ctx, t.chatID, m.MessageID, ticketID); err != nil {
+			log.Printf("link support message: %v", err)
+		}
+	}
+}
+
+// forwardToSupport forwards a user message and reports whether any support
+// target received it.
+func (b *Bot) forwardToSupport(ctx context.Context, ticketID int, msg *tgbotapi.Message) bool {
+	delivered := false
+	for _, t := range b.supportTargets() {
+		params := tgbotapi.Params{}
+		params.AddNonZero64("from_chat_id", msg.Chat.ID)
+		params.AddNonZero("message_id", msg.MessageID)
+		m, err := b.supportRequest(ctx, t, "forwardMessage", params)
+		if err != nil {
+			log.Printf("forward to support chat %d: %v", t.chatID, err)
+			continue
+		}
+		delivered = true
+		if err := b.store.LinkSupportMessage(ctx, t.chatID, m.MessageID, ticketID); err != nil {
+			log.Printf("link support message: %v", err)
+		}
+	}
+	return delivered
+}
+
+func ticketMarkup(loc *i18n.Localizer, ticket *storage.Ticket) tgbotapi.InlineKeyboardMarkup {
+	if ticket.Status == "open" {
+		return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(loc.T("support.close_button"), callbackData("ticket", "close", ticket.ID)),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(loc.T("support.reopen_button"), callbackData("ticket", "reopen", ticket.ID)),
+	))
+}
+
+func (b *Bot) handleSupport(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
+		return
+	}
+	b.reply(msg.Chat.ID, b.openTicket(ctx, user, msg.From))
+}
+
+// openTicket returns the user's open ticket, creating one if needed, and
+// switches the user to support mode. It returns the reply for the user.
+func (b *Bot) openTicket(ctx context.Context, user *storage.User, from *tgbotapi.User) string {
+	loc := b.loc(user, from)
+	ticket, err := b.store.GetOpenTicketByUser(ctx, user.ID)
+	if err != nil {
+		log.Printf("get open ticket: %v", err)
+		return loc.T("support.failed")
+	}
+	text := "support.continue"
+	if ticket == nil {
+		ticket, err = b.store.CreateTicket(ctx, user.ID)
+		if err != nil {
+			log.Printf("create ticket: %v", err)
+			return loc.T("support.failed")
+		}
+		text = "support.opened"
+		adminLoc := b.defaultLoc()
+		markup := ticketMarkup(adminLoc, ticket)
+		b.postToSupport(ctx, ticket.ID, adminLoc.T("admin.support.new", i18n.Args{
+			"ID":         ticket.ID,
+			"Username":   user.Username.String,
+			"TelegramID": user.TelegramID,
+		}), &markup)
+	}
+
+	b.mu.Lock()
+	b.supportMode[from.ID] = ticket.ID
+	b.mu.Unlock()
+	return loc.T(text, i18n.Args{"ID": ticket.ID})
+}
+
+// handleSupportMessage forwards a user's private message to their open
+// ticket. Text always goes to an open ticket; photos only in support mode,
+// since otherwise they are payment screenshots.
+func (b *Bot) handleSupportMessage(ctx context.Context, msg *tgbotapi.Message) bool {
+	if !msg.Chat.IsPrivate() {
+		return false
+	}
+	b.mu.Lock()
+	ticketID, inMode := b.supportMode[msg.From.ID]
+	b.mu.Unlock()
+	if msg.Photo != nil && !inMode {
+		return false
+	}
+
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		return false
+	}
+	ticket, err := b.store.GetOpenTicketByUser(ctx, user.ID)
+	if err != nil {
+		log.Printf("get open ticket: %v", err)
+		return false
+	}
+	if ticket == nil {
+		if inMode {
+			b.mu.Lock()
+			delete(b.supportMode, msg.From.ID)
+			b.mu.Unlock()
+		}
+		return false
+	}
+	if ticket.ID != ticketID && inMode {
+		b.mu.Lock()
+		b.supportMode[msg.From.ID] = ticket.ID
+		b.mu.Unlock()
+	}
+
+	text, photo := msg.Text, ""
+	if len(msg.Photo) > 0 {
+		text, photo = msg.Caption, msg.Photo[len(msg.Photo)-1].FileID
+	}
+	if err := b.store.AddSupportMessage(ctx, ticket.ID, false, msg.From.ID, text, photo); err != nil {
+		log.Printf("add support message: %v", err)
+	}
+	if !b.forwardToSupport(ctx, ticket.ID, msg) {
+		b.reply(msg.Chat.ID, b.loc(user, msg.From).T("support.failed"))
+	}
+	return true
+}
+
+// handleSupportReply relays an admin's reply to a forwarded ticket message
+// back to the user.
+func (b *Bot) handleSupportReply(ctx context.Context, msg *tgbotapi.Message) bool {
+	if msg.ReplyToMessage == nil || !b.isAdmin(msg.From.ID) {
+		return false
+	}
+	ticketID, err := b.store.FindTicketByMessage(ctx, msg.Chat.ID, msg.ReplyToMessage.MessageID)
+	if err != nil {
+		log.Printf("find ticket by message: %v", err)
+		return false
+	}
+	if ticketID == 0 {
+		return false
+	}
+	ticket, err := b.store.GetTicket(ctx, ticketID)
+	if err != nil {
+		log.Printf("get ticket: %v", err)
+		return true
+	}
+	user, err := b.store.GetUserByID(ctx, ticket.UserID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		return true
+	}
+
+	loc := b.loc(user, nil)
+	text, photo := msg.Text, ""
+	if len(msg.Photo) > 0 {
+		text, photo = msg.Caption, msg.Photo[len(msg.Photo)-1].FileID
+	}
+	if err := b.store.AddSupportMessage(ctx, ticket.ID, true, msg.From.ID, text, photo); err != nil {
+		log.Printf("add support message: %v", err)
+	}
+	b.send(outbox.Message{
+		ChatID:      user.TelegramID,
+		Text:        loc.T("support.reply", i18n.Args{"ID": ticket.ID, "Text": text}),
+		PhotoFileID: photo,
+	})
+	if ticket.Status != "open" {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("admin.support.reply_closed", i18n.Args{"ID": ticket.ID}))
+	}
+	return true
+}
+
+func (b *Bot) handleCloseTicket(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := b.store.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil || user == nil {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("common.start_first"))
+		return
+	}
+	loc := b.loc(user, msg.From)
+	ticket, err := b.store.GetOpenTicketByUser(ctx, user.ID)
+	if err != nil || ticket == nil {
+		b.reply(msg.Chat.ID, loc.T("support.no_ticket"))
+		return
+	}
+	b.setTicketStatus(ctx, ticket, user, "closed", false)
+}
+
+// setTicketStatus closes or reopens a ticket and notifies the other side.
+func (b *Bot) setTicketStatus(ctx context.Context, ticket *storage.Ticket, user *storage.User, status string, byAdmin bool) {
+	if err := b.store.SetTicketStatus(ctx, ticket.ID, status); err != nil {
+		log.Printf("set ticket status: %v", err)
+		return
+	}
+	ticket.Status = status
+
+	b.mu.Lock()
+	if status == "open" {
+		b.supportMode[user.TelegramID] = ticket.ID
+	} else {
+		delete(b.supportMode, user.TelegramID)
+	}
+	b.mu.Unlock()
+
+	loc := b.loc(user, nil)
+	markup := ticketMarkup(loc, ticket)
+	b.send(outbox.Message{
+		ChatID: user.TelegramID,
+		Text:   loc.T("support.status."+status, i18n.Args{"ID": ticket.ID}),
+		Markup: &markup,
+	})
+	if !byAdmin {
+		adminLoc := b.defaultLoc()
+		adminMarkup := ticketMarkup(adminLoc, ticket)
+		b.postToSupport(ctx, ticket.ID, adminLoc.T("admin.support.status."+status, i18n.Args{"ID": ticket.ID}), &adminMarkup)
+	}
+}
+
+func (b *Bot) handleTicketCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, args []string) {
+	if len(args) == 1 && args[0] == "new" {
+		user, err := b.store.GetUserByTelegramID(ctx, callback.From.ID)
+		if err != nil || user == nil {
+			b.editMenu(callback, b.fromLoc(callback.From).T("common.start_first"), nil)
+			return
+		}
+		back := backMarkup(b.loc(user, callback.From))
+		b.editMenu(callback, b.openTicket(ctx, user, callback.From), &back)
+		return
+	}
+	if len(args) != 2 {
+		return
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return
+	}
+	ticket, err := b.store.GetTicket(ctx, id)
+	if err != nil {
+		log.Printf("get ticket: %v", err)
+		return
+	}
+	user, err := b.store.GetUserByID(ctx, ticket.UserID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		return
+	}
+	admin := b.isAdmin(callback.From.ID)
+	if !admin && user.TelegramID != callback.From.ID {
+		return
+	}
+
+	status := map[string]string{"close": "closed", "reopen": "open"}[args[0]]
+	if status == "" || status == ticket.Status {
+		return
+	}
+	b.setTicketStatus(ctx, ticket, user, status, admin)
+	if admin {
+		loc := b.fromLoc(callback.From)
+		markup := ticketMarkup(loc, ticket)
+		edit := tgbotapi.NewEditMessageReplyMarkup(callback.Message.Chat.ID, callback.Message.MessageID, markup)
+		if _, err := b.outbox.Send(ctx, callback.Message.Chat.ID, edit); err != nil && !isNotModified(err) {
+			log.Printf("edit ticket markup: %v", err)
+		}
+	} else if callback.Message != nil {
+		edit := tgbotapi.NewEditMessageReplyMarkup(callback.Message.Chat.ID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+		if _, err := b.outbox.Send(ctx, callback.Message.Chat.ID, edit); err != nil && !isNotModified(err) {
+			log.Printf("edit ticket markup: %v", err)
+		}
+	}
+}
+
+func (b *Bot) handleTickets(ctx context.Context, msg *tgbotapi.Message) {
+	loc := b.fromLoc(msg.From)
+	list, err := b.store.ListOpenTickets(ctx)
+	if err != nil {
+		log.Printf("list open tickets: %v", err)
+		b.reply(msg.Chat.ID, loc.T("admin.support.list_failed"))
+		return
+	}
+	if len(list) == 0 {
+		b.reply(msg.Chat.ID, loc.T("admin.support.none"))
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(loc.N("admin.support.list", len(list)))
+	for _, t := range list {
+		fmt.Fprintf(&sb, "\n#%d @%s (%d) — %s, %s", t.ID, t.Username.String, t.TelegramID,
+			loc.N("admin.support.messages", t.Messages), t.UpdatedAt.Format("02.01 15:04"))
+	}
+	b.reply(msg.Chat.ID, sb.String())
+}
+
+// handleAdminTicketCommand implements /closeticket <id> and /reopen <id>.
+func (b *Bot) handleAdminTicketCommand(ctx context.Context, msg *tgbotapi.Message, status string) {
+	loc := b.fromLoc(msg.From)
+	id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(msg.CommandArguments()), "#"))
+	if err != nil {
+		b.reply(msg.Chat.ID, loc.T("admin.support.usage"))
+		return
+	}
+	ticket, err := b.store.GetTicket(ctx, id)
+	if err != nil {
+		b.reply(msg.Chat.ID, loc.T("admin.support.not_found", i18n.Args{"ID": id}))
+		return
+	}
+	user, err := b.store.GetUserByID(ctx, ticket.UserID)
+	if err != nil {
+		log.Printf("get user: %v", err)
+		return
+	}
+	if ticket.Status != status {
+		b.setTicketStatus(ctx, ticket, user, status, true)
+	}
+	b.reply(msg.Chat.ID, loc.T("admin.support.status."+status, i18n.Args{"ID": ticket.ID}))
+}
+
+// cancelSupport leaves support mode, so photos are treated as payments
+// again. The ticket itself stays open.
+func (b *Bot) cancelSupport(msg *tgbotapi.Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.supportMode[msg.From.ID]; !ok {
+		return false
+	}
+	delete(b.supportMode, msg.From.ID)
+	return true
+}
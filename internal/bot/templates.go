@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"context"
+	"html"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/outbox"
+	"vpn-bot/internal/templates"
+)
+
+type templateTarget struct {
+	key  string
+	lang string
+}
+
+// renderTemplate renders an admin-editable message for sending with HTML
+// formatting. String placeholders are escaped.
+func (b *Bot) renderTemplate(loc *i18n.Localizer, key string, args ...i18n.Args) string {
+	escaped := i18n.Args{}
+	for _, a := range args {
+		for k, v := range a {
+			if s, ok := v.(string); ok {
+				v = html.EscapeString(s)
+			}
+			escaped[k] = v
+		}
+	}
+	return loc.T(key, escaped)
+}
+
+func (b *Bot) replyTemplate(chatID int64, loc *i18n.Localizer, key string, args ...i18n.Args) {
+	b.replyHTML(chatID, b.renderTemplate(loc, key, args...))
+}
+
+func (b *Bot) replyHTML(chatID int64, text string) {
+	b.send(outbox.Message{ChatID: chatID, Text: text, ParseMode: tgbotapi.ModeHTML})
+}
+
+// templateArgs parses "<key> [lang]" command arguments. The language
+// defaults to the admin's own.
+func (b *Bot) templateArgs(msg *tgbotapi.Message) (templateTarget, string, bool) {
+	loc := b.fromLoc(msg.From)
+	args := msg.CommandArguments()
+	head, body, _ := strings.Cut(args, "\n")
+	fields := strings.Fields(head)
+	if len(fields) == 0 {
+		b.reply(msg.Chat.ID, loc.T("admin.templates.usage"))
+		return templateTarget{}, "", false
+	}
+	t := templateTarget{key: fields[0], lang: loc.Lang()}
+	if len(fields) > 1 {
+		t.lang = fields[1]
+	}
+	if _, ok := templates.Lookup(t.key); !ok {
+		b.reply(msg.Chat.ID, loc.T("admin.templates.unknown", i18n.Args{"Key": t.key}))
+		return templateTarget{}, "", false
+	}
+	if b.i18n.Match(t.lang) != t.lang {
+		b.reply(msg.Chat.ID, loc.T("admin.templates.unknown_language", i18n.Args{"Language": t.lang}))
+		return templateTarget{}, "", false
+	}
+	return t, strings.TrimSpace(body), true
+}
+
+func (b *Bot) handleTemplates(msg *tgbotapi.Message) {
+	loc := b.fromLoc(msg.From)
+	var sb strings.Builder
+	sb.WriteString(loc.T("admin.templates.list"))
+	for _, def := range templates.Editable {
+		sb.WriteString("\n• ")
+		sb.WriteString(def.Key)
+		for _, lang := range b.i18n.Languages() {
+			if _, edited := b.templates.Lookup(lang, def.Key); edited {
+				sb.WriteString(" [" + lang + "*]")
+			}
+		}
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString(loc.T("admin.templates.help"))
+	b.reply(msg.Chat.ID, sb.String())
+}
+
+func (b *Bot) handleTemplate(msg *tgbotapi.Message) {
+	t, _, ok := b.templateArgs(msg)
+	if !ok {
+		return
+	}
+	loc := b.fromLoc(msg.From)
+	body, edited := b.templates.Current(t.lang, t.key)
+	header := loc.T("admin.templates.default", i18n.Args{"Key": t.key, "Language": t.lang})
+	if edited {
+		header = loc.T("admin.templates.edited", i18n.Args{"Key": t.key, "Language": t.lang})
+	}
+	b.reply(msg.Chat.ID, header)
+	b.reply(msg.Chat.ID, body)
+}
+
+func (b *Bot) handleSetTemplate(ctx context.Context, msg *tgbotapi.Message) {
+	t, body, ok := b.templateArgs(msg)
+	if !ok {
+		return
+	}
+	if body != "" {
+		b.saveTemplate(ctx, msg, t, body)
+		return
+	}
+	b.mu.Lock()
+	b.editingTemplate[msg.From.ID] = t
+	b.mu.Unlock()
+	b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("admin.templates.ask_body", i18n.Args{"Key": t.key, "Language": t.lang}))
+}
+
+func (b *Bot) handlePreviewTemplate(msg *tgbotapi.Message) {
+	t, body, ok := b.templateArgs(msg)
+	if !ok {
+		return
+	}
+	if body == "" {
+		body, _ = b.templates.Current(t.lang, t.key)
+	}
+	b.previewTemplate(msg, t, body)
+}
+
+func (b *Bot) previewTemplate(msg *tgbotapi.Message, t templateTarget, body string) bool {
+	out, err := b.templates.Preview(t.lang, t.key, body)
+	if err != nil {
+		b.reply(msg.Chat.ID, b.fromLoc(msg.From).T("admin.templates.invalid", i18n.Args{"Error": err}))
+		return false
+	}
+	b.send(outbox.Message{ChatID: msg.Chat.ID, Text: out, ParseMode: tgbotapi.ModeHTML})
+	return true
+}
+
+func (b *Bot) handleResetTemplate(ctx context.Context, msg *tgbotapi.Message) {
+	t, _, ok := b.templateArgs(msg)
+	if !ok {
+		return
+	}
+	loc := b.fromLoc(msg.From)
+	if err := b.templates.Reset(ctx, t.lang, t.key); err != nil {
+		log.Printf("reset template: %v", err)
+		b.reply(msg.Chat.ID, loc.T("admin.templates.save_failed"))
+		return
+	}
+	b.reply(msg.Chat.ID, loc.T("admin.templates.reset", i18n.Args{"Key": t.key, "Language": t.lang}))
+}
+
+// handleTemplateText accepts the new body of a template being edited.
+func (b *Bot) handleTemplateText(ctx context.Context, msg *tgbotapi.Message) bool {
+	b.mu.Lock()
+	t, ok := b.editingTemplate[msg.From.ID]
+	delete(b.editingTemplate, msg.From.ID)
+	b.mu.Unlock()
+	if !ok {
+		return false
+	}
+	b.saveTemplate(ctx, msg, t, msg.Text)
+	return true
+}
+
+func (b *Bot) saveTemplate(ctx context.Context, msg *tgbotapi.Message, t templateTarget, body string) {
+	if !b.previewTemplate(msg, t, body) {
+		return
+	}
+	loc := b.fromLoc(msg.From)
+	if err := b.templates.Set(ctx, t.lang, t.key, body, msg.From.ID); err != nil {
+		log.Printf("save template: %v", err)
+		b.reply(msg.Chat.ID, loc.T("admin.templates.save_failed"))
+		return
+	}
+	b.reply(msg.Chat.ID, loc.T("admin.templates.saved", i18n.Args{"Key": t.key, "Language": t.lang}))
+}
+
+func (b *Bot) cancelTemplateEdit(msg *tgbotapi.Message) bool {
+	b.mu.Lock()
+	_, ok := b.editingTemplate[msg.From.ID]
+	delete(b.editingTemplate, msg.From.ID)
+	b.mu.Unlock()
+	return ok
+}
@@ -5,16 +5,76 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// ServerConfig is a VPN server listed in SERVERS. The 3x-ui panel
+// configured by PANEL_*, if any, is the server "main".
+type ServerConfig struct {
+	Name string
+	// Type is "marzban", "wgeasy" or "outline".
+	Type string
+	URL  string
+	User string
+	Pass string
+	// CertSHA256 pins the certificate of an Outline management API.
+	CertSHA256 string
+}
+
 type Config struct {
 	TelegramToken string
 	AdminIDs      []int64
 	PanelURL      string
 	PanelUser     string
 	PanelPass     string
+	// PanelAuth is "password" for a session login or "token" for
+	// PanelToken.
+	PanelAuth  string
+	PanelToken string
+	// PanelTOTPSecret is the base32 two-factor secret of a password login.
+	PanelTOTPSecret string
+	// PanelSessionKey encrypts the saved panel session; without it the
+	// session is not saved across restarts.
+	PanelSessionKey string
 
 	DBDSN         string
+
+	SubscriptionURL string
+	GuideMediaURL   string
+	RedirectURL     string
+
+	SupportChatID   int64
+	SupportThreadID int
+
+	KeyRotationInterval time.Duration
+	TrialDays           int
+
+	// PanelRetries is how many times idempotent calls to a server are tried
+	// while it is unavailable, PanelRetryDelay the first delay.
+	PanelRetries    int
+	PanelRetryDelay time.Duration
+	// A server's circuit breaker opens after PanelBreakerFailures failed
+	// calls in a row, for PanelBreakerCooldown.
+	PanelBreakerFailures int
+	PanelBreakerCooldown time.Duration
+
+	ReconcileSource   string
+	ReconcileSchedule string
+
+	// SharingSchedule is the cron spec of the account-sharing check; empty
+	// disables it.
+	SharingSchedule  string
+	SharingWindow    time.Duration
+	SharingTolerance int
+	SharingByASN     bool
+	SharingAction    string
+
+	Servers []ServerConfig
+	// DefaultServer gets new clients. PrimaryServer keeps client IDs
+	// without a server prefix: "main" if the 3x-ui panel is configured,
+	// otherwise the default server.
+	DefaultServer string
+	PrimaryServer string
 }
 
 func Load() (*Config, error) {
@@ -42,29 +102,217 @@ func Load() (*Config, error) {
 	}
 
 	cfg.PanelURL = os.Getenv("PANEL_URL")
-	if cfg.PanelURL == "" {
-		return nil, fmt.Errorf("PANEL_URL is required")
+	if cfg.PanelURL != "" {
+		if err := loadPanelAuth(cfg); err != nil {
+			return nil, err
+		}
 	}
 
-	cfg.PanelUser = os.Getenv("PANEL_USER")
-	if cfg.PanelUser == "" {
-		return nil, fmt.Errorf("PANEL_USER is required")
+	cfg.DBDSN = os.Getenv("DB_DSN")
+	if cfg.DBDSN == "" {
+		return nil, fmt.Errorf("DB_DSN is required")
 	}
 
-	cfg.PanelPass = os.Getenv("PANEL_PASS")
-	if cfg.PanelPass == "" {
-		return nil, fmt.Errorf("PANEL_PASS is required")
+	cfg.SubscriptionURL = os.Getenv("SUBSCRIPTION_URL")
+	cfg.GuideMediaURL = strings.TrimSuffix(os.Getenv("GUIDE_MEDIA_URL"), "/")
+	cfg.RedirectURL = os.Getenv("DEEPLINK_REDIRECT_URL")
 
+	if v := os.Getenv("SUPPORT_CHAT_ID"); v != "" {
+		id, err := parseInt64(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SUPPORT_CHAT_ID %q: %w", v, err)
+		}
+		cfg.SupportChatID = id
+	}
+	if v := os.Getenv("SUPPORT_THREAD_ID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SUPPORT_THREAD_ID %q: %w", v, err)
+		}
+		cfg.SupportThreadID = id
 	}
 
-	cfg.DBDSN = os.Getenv("DB_DSN")
-	if cfg.DBDSN == "" {
-		return nil, fmt.Errorf("DB_DSN is required")
+	cfg.KeyRotationInterval = 24 * time.Hour
+	if v := os.Getenv("KEY_ROTATION_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KEY_ROTATION_INTERVAL %q: %w", v, err)
+		}
+		cfg.KeyRotationInterval = d
+	}
+
+	cfg.TrialDays = 3
+	if v := os.Getenv("TRIAL_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRIAL_DAYS %q: %w", v, err)
+		}
+		cfg.TrialDays = days
+	}
+
+	cfg.PanelRetries = 3
+	if v := os.Getenv("PANEL_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PANEL_RETRIES %q: %w", v, err)
+		}
+		cfg.PanelRetries = n
+	}
+
+	cfg.PanelRetryDelay = 500 * time.Millisecond
+	if v := os.Getenv("PANEL_RETRY_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PANEL_RETRY_DELAY %q: %w", v, err)
+		}
+		cfg.PanelRetryDelay = d
+	}
+
+	cfg.PanelBreakerFailures = 5
+	if v := os.Getenv("PANEL_BREAKER_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PANEL_BREAKER_FAILURES %q: %w", v, err)
+		}
+		cfg.PanelBreakerFailures = n
+	}
+
+	cfg.PanelBreakerCooldown = 30 * time.Second
+	if v := os.Getenv("PANEL_BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PANEL_BREAKER_COOLDOWN %q: %w", v, err)
+		}
+		cfg.PanelBreakerCooldown = d
+	}
+
+	cfg.ReconcileSource = os.Getenv("RECONCILE_SOURCE")
+	cfg.ReconcileSchedule = "30 4 * * *"
+	if v, ok := os.LookupEnv("RECONCILE_SCHEDULE"); ok {
+		cfg.ReconcileSchedule = v
+	}
+
+	cfg.SharingSchedule = "@every 10m"
+	if v, ok := os.LookupEnv("SHARING_SCHEDULE"); ok {
+		cfg.SharingSchedule = v
+	}
+
+	cfg.SharingWindow = 24 * time.Hour
+	if v := os.Getenv("SHARING_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHARING_WINDOW %q: %w", v, err)
+		}
+		cfg.SharingWindow = d
+	}
+
+	cfg.SharingTolerance = 1
+	if v := os.Getenv("SHARING_TOLERANCE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHARING_TOLERANCE %q: %w", v, err)
+		}
+		cfg.SharingTolerance = n
+	}
+
+	if v := os.Getenv("SHARING_BY_ASN"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHARING_BY_ASN %q: %w", v, err)
+		}
+		cfg.SharingByASN = b
+	}
+	cfg.SharingAction = os.Getenv("SHARING_ACTION")
+
+	if v := os.Getenv("SERVERS"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			server, err := loadServer(name)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Servers = append(cfg.Servers, server)
+		}
+	}
+	if cfg.PanelURL == "" && len(cfg.Servers) == 0 {
+		return nil, fmt.Errorf("PANEL_URL or SERVERS is required")
+	}
+	cfg.DefaultServer = os.Getenv("DEFAULT_SERVER")
+	if cfg.DefaultServer == "" {
+		cfg.DefaultServer = "main"
+		if cfg.PanelURL == "" {
+			cfg.DefaultServer = cfg.Servers[0].Name
+		}
+	}
+	cfg.PrimaryServer = "main"
+	if cfg.PanelURL == "" {
+		cfg.PrimaryServer = cfg.DefaultServer
 	}
 
 	return cfg, nil
 }
 
+// loadPanelAuth reads the credentials of the 3x-ui panel at PANEL_URL.
+func loadPanelAuth(cfg *Config) error {
+	cfg.PanelToken = os.Getenv("PANEL_TOKEN")
+	cfg.PanelAuth = os.Getenv("PANEL_AUTH")
+	if cfg.PanelAuth == "" {
+		cfg.PanelAuth = "password"
+		if cfg.PanelToken != "" && os.Getenv("PANEL_PASS") == "" {
+			cfg.PanelAuth = "token"
+		}
+	}
+	switch cfg.PanelAuth {
+	case "password":
+		cfg.PanelUser = os.Getenv("PANEL_USER")
+		if cfg.PanelUser == "" {
+			return fmt.Errorf("PANEL_USER is required")
+		}
+
+		cfg.PanelPass = os.Getenv("PANEL_PASS")
+		if cfg.PanelPass == "" {
+			return fmt.Errorf("PANEL_PASS is required")
+		}
+		cfg.PanelTOTPSecret = os.Getenv("PANEL_TOTP_SECRET")
+		cfg.PanelSessionKey = os.Getenv("PANEL_SESSION_KEY")
+	case "token":
+		if cfg.PanelToken == "" {
+			return fmt.Errorf("PANEL_TOKEN is required")
+		}
+	default:
+		return fmt.Errorf("PANEL_AUTH must be password or token, got %q", cfg.PanelAuth)
+	}
+	return nil
+}
+
+// loadServer reads SERVER_<NAME>_TYPE, _URL, _USER, _PASS and _CERT_SHA256.
+func loadServer(name string) (ServerConfig, error) {
+	prefix := "SERVER_" + strings.ToUpper(name) + "_"
+	server := ServerConfig{
+		Name:       name,
+		Type:       os.Getenv(prefix + "TYPE"),
+		URL:        os.Getenv(prefix + "URL"),
+		User:       os.Getenv(prefix + "USER"),
+		Pass:       os.Getenv(prefix + "PASS"),
+		CertSHA256: os.Getenv(prefix + "CERT_SHA256"),
+	}
+	if name == "main" || strings.Contains(name, ":") {
+		return server, fmt.Errorf("invalid server name %q", name)
+	}
+	switch server.Type {
+	case "marzban", "wgeasy", "outline":
+	default:
+		return server, fmt.Errorf("%sTYPE must be marzban, wgeasy or outline, got %q", prefix, server.Type)
+	}
+	if server.URL == "" {
+		return server, fmt.Errorf("%sURL is required", prefix)
+	}
+	return server, nil
+}
+
 func parseInt64(s string) (int64, error) {
 	return strconv.ParseInt(s, 10, 64)
 }
@@ -0,0 +1,280 @@
+package i18n
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// DefaultLanguage is used when the user's language has no catalog.
+const DefaultLanguage = "ru"
+
+// Args holds template placeholders, e.g. {{.Expires}}.
+type Args map[string]interface{}
+
+type localeFile struct {
+	Name       string                     `json:"name"`
+	DateFormat string                     `json:"date_format"`
+	Months     []string                   `json:"months"`
+	Messages   map[string]json.RawMessage `json:"messages"`
+}
+
+type locale struct {
+	name       string
+	dateFormat string
+	months     []string
+	messages   map[string]map[string]*template.Template
+	sources    map[string]map[string]string
+}
+
+// Overrides supplies replacement texts for catalog messages, e.g. templates
+// edited by admins.
+type Overrides interface {
+	Lookup(lang, key string) (string, bool)
+}
+
+// Catalog holds the message catalogs of all embedded locales.
+type Catalog struct {
+	locales   map[string]*locale
+	overrides Overrides
+}
+
+// Load parses the locale files embedded in the binary.
+func Load() (*Catalog, error) {
+	files, err := localeFS.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{locales: make(map[string]*locale)}
+	for _, f := range files {
+		data, err := localeFS.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		lang := strings.TrimSuffix(f.Name(), ".json")
+		loc, err := parseLocale(lang, data)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", f.Name(), err)
+		}
+		c.locales[lang] = loc
+	}
+	if _, ok := c.locales[DefaultLanguage]; !ok {
+		return nil, fmt.Errorf("i18n: default locale %q is missing", DefaultLanguage)
+	}
+	return c, nil
+}
+
+func parseLocale(lang string, data []byte) (*locale, error) {
+	var f localeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Months) != 0 && len(f.Months) != 12 {
+		return nil, fmt.Errorf("months: want 12 names, got %d", len(f.Months))
+	}
+	loc := &locale{
+		name:       f.Name,
+		dateFormat: f.DateFormat,
+		months:     f.Months,
+		messages:   make(map[string]map[string]*template.Template),
+		sources:    make(map[string]map[string]string),
+	}
+	for key, raw := range f.Messages {
+		forms := make(map[string]string)
+		var single string
+		if err := json.Unmarshal(raw, &single); err == nil {
+			forms["other"] = single
+		} else if err := json.Unmarshal(raw, &forms); err != nil {
+			return nil, fmt.Errorf("message %q: %w", key, err)
+		}
+		loc.messages[key] = make(map[string]*template.Template)
+		loc.sources[key] = forms
+		for form, text := range forms {
+			tmpl, err := template.New(key).Option("missingkey=zero").Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("message %q: %w", key, err)
+			}
+			loc.messages[key][form] = tmpl
+		}
+	}
+	return loc, nil
+}
+
+// SetOverrides installs o to be consulted before the embedded messages. Only
+// the "other" form of a message can be overridden.
+func (c *Catalog) SetOverrides(o Overrides) {
+	c.overrides = o
+}
+
+// Source returns the embedded text of the message key in lang.
+func (c *Catalog) Source(lang, key string) (string, bool) {
+	loc, ok := c.locales[lang]
+	if !ok {
+		return "", false
+	}
+	text, ok := loc.sources[key]["other"]
+	return text, ok
+}
+
+// Languages returns the codes of all available locales.
+func (c *Catalog) Languages() []string {
+	langs := make([]string, 0, len(c.locales))
+	for lang := range c.locales {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Name returns the human-readable name of the locale.
+func (c *Catalog) Name(lang string) string {
+	if loc, ok := c.locales[lang]; ok {
+		return loc.name
+	}
+	return lang
+}
+
+// Match maps a Telegram language code such as "en-US" to an available locale.
+func (c *Catalog) Match(code string) string {
+	code = strings.ToLower(code)
+	if _, ok := c.locales[code]; ok {
+		return code
+	}
+	if base, _, ok := strings.Cut(code, "-"); ok {
+		if _, ok := c.locales[base]; ok {
+			return base
+		}
+	}
+	return DefaultLanguage
+}
+
+// For returns a localizer for lang, falling back to the default language.
+func (c *Catalog) For(lang string) *Localizer {
+	if _, ok := c.locales[lang]; !ok {
+		lang = DefaultLanguage
+	}
+	return &Localizer{catalog: c, lang: lang}
+}
+
+// Localizer renders messages in a single language.
+type Localizer struct {
+	catalog *Catalog
+	lang    string
+}
+
+func (l *Localizer) Lang() string {
+	return l.lang
+}
+
+// T renders the message key with optional placeholders.
+func (l *Localizer) T(key string, args ...Args) string {
+	return l.render(key, "other", merge(args))
+}
+
+// N renders the plural form of key matching n. The count is available to the
+// template as {{.Count}}.
+func (l *Localizer) N(key string, n int, args ...Args) string {
+	data := merge(args)
+	data["Count"] = n
+	return l.render(key, pluralForm(l.lang, n), data)
+}
+
+// Date formats t according to the locale's conventions.
+func (l *Localizer) Date(t time.Time) string {
+	loc := l.catalog.locales[l.lang]
+	layout := loc.dateFormat
+	if layout == "" {
+		layout = "2006-01-02"
+	}
+	if len(loc.months) == 0 || !strings.Contains(layout, "January") {
+		return t.Format(layout)
+	}
+	// Month names are substituted after formatting since the time package only
+	// knows English names.
+	out := t.Format(strings.Replace(layout, "January", "\x00", 1))
+	return strings.Replace(out, "\x00", loc.months[t.Month()-1], 1)
+}
+
+// Render executes text as a message template. Unlike T it reports unknown
+// placeholders and syntax errors, which makes it suitable for validating
+// user-supplied templates.
+func (l *Localizer) Render(text string, args Args) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, args); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (l *Localizer) render(key, form string, data Args) string {
+	if out, ok := l.override(key, form, data); ok {
+		return out
+	}
+	tmpl := l.lookup(key, form)
+	if tmpl == nil {
+		log.Printf("i18n: missing message %q for %q", key, l.lang)
+		return key
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("i18n: render %q: %v", key, err)
+		return key
+	}
+	return buf.String()
+}
+
+func (l *Localizer) override(key, form string, data Args) (string, bool) {
+	if l.catalog.overrides == nil || form != "other" {
+		return "", false
+	}
+	text, ok := l.catalog.overrides.Lookup(l.lang, key)
+	if !ok {
+		return "", false
+	}
+	out, err := l.Render(text, data)
+	if err != nil {
+		log.Printf("i18n: render override %q for %q: %v", key, l.lang, err)
+		return "", false
+	}
+	return out, true
+}
+
+func (l *Localizer) lookup(key, form string) *template.Template {
+	for _, lang := range []string{l.lang, DefaultLanguage} {
+		forms, ok := l.catalog.locales[lang].messages[key]
+		if !ok {
+			continue
+		}
+		if tmpl, ok := forms[form]; ok {
+			return tmpl
+		}
+		if tmpl, ok := forms["other"]; ok {
+			return tmpl
+		}
+	}
+	return nil
+}
+
+func merge(args []Args) Args {
+	data := Args{}
+	for _, a := range args {
+		for k, v := range a {
+			data[k] = v
+		}
+	}
+	return data
+}
@@ -0,0 +1,41 @@
+package i18n
+
+import "testing"
+
+func TestPluralForm(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"en", 0, "other"},
+		{"en", 1, "one"},
+		{"en", 2, "other"},
+		{"en", 11, "other"},
+		{"en", -1, "one"},
+		{"ru", 0, "many"},
+		{"ru", 1, "one"},
+		{"ru", 2, "few"},
+		{"ru", 4, "few"},
+		{"ru", 5, "many"},
+		{"ru", 11, "many"},
+		{"ru", 12, "many"},
+		{"ru", 14, "many"},
+		{"ru", 21, "one"},
+		{"ru", 22, "few"},
+		{"ru", 25, "many"},
+		{"ru", 101, "one"},
+		{"ru", 111, "many"},
+		{"ru", 112, "many"},
+		{"ru", 122, "few"},
+		{"ru", -3, "few"},
+		{"uk", 23, "few"},
+		{"de", 1, "one"},
+		{"de", 3, "other"},
+	}
+	for _, tt := range tests {
+		if got := pluralForm(tt.lang, tt.n); got != tt.want {
+			t.Errorf("pluralForm(%q, %d) = %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
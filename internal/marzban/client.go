@@ -0,0 +1,263 @@
+// Package marzban is a client for the Marzban panel API.
+package marzban
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for users the panel does not have.
+var ErrNotFound = errors.New("marzban: user not found")
+
+// StatusError is an error answer of the panel other than not found.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("marzban request failed: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("marzban request failed: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// tokenMargin is how long before its expiry a token is renewed.
+const tokenMargin = time.Minute
+
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// User is a Marzban user. Expire is a Unix time and DataLimit a byte count;
+// zero means no limit for both.
+type User struct {
+	Username        string                            `json:"username"`
+	Status          string                            `json:"status,omitempty"`
+	Expire          int64                             `json:"expire"`
+	DataLimit       int64                             `json:"data_limit"`
+	UsedTraffic     int64                             `json:"used_traffic,omitempty"`
+	Proxies         map[string]map[string]interface{} `json:"proxies,omitempty"`
+	Links           []string                          `json:"links,omitempty"`
+	SubscriptionURL string                            `json:"subscription_url,omitempty"`
+	// CreatedAt is an ISO time in UTC without a zone, as Marzban sends it.
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// UserModify holds the fields of a user to change; nil fields are kept.
+type UserModify struct {
+	Status    *string `json:"status,omitempty"`
+	Expire    *int64  `json:"expire,omitempty"`
+	DataLimit *int64  `json:"data_limit,omitempty"`
+}
+
+type usersResponse struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+}
+
+func New(baseURL, username, password string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/",
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (c *Client) CreateUser(ctx context.Context, user User) (*User, error) {
+	var created User
+	if err := c.do(ctx, http.MethodPost, "api/user", user, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) ModifyUser(ctx context.Context, username string, mod UserModify) error {
+	return c.do(ctx, http.MethodPut, "api/user/"+url.PathEscape(username), mod, nil)
+}
+
+func (c *Client) DeleteUser(ctx context.Context, username string) error {
+	return c.do(ctx, http.MethodDelete, "api/user/"+url.PathEscape(username), nil, nil)
+}
+
+func (c *Client) GetUser(ctx context.Context, username string) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "api/user/"+url.PathEscape(username), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers returns all users, fetching them page by page.
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	const pageSize = 500
+	var users []User
+	for offset := 0; ; offset += pageSize {
+		var resp usersResponse
+		path := fmt.Sprintf("api/users?offset=%d&limit=%d", offset, pageSize)
+		if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return nil, err
+		}
+		users = append(users, resp.Users...)
+		if len(resp.Users) < pageSize || len(users) >= resp.Total {
+			return users, nil
+		}
+	}
+}
+
+// SubscriptionURL returns the user's absolute subscription link. Marzban
+// returns it relative to the panel unless XRAY_SUBSCRIPTION_URL_PREFIX is
+// set.
+func (c *Client) SubscriptionURL(user *User) string {
+	if user.SubscriptionURL == "" || strings.HasPrefix(user.SubscriptionURL, "http") {
+		return user.SubscriptionURL
+	}
+	return c.baseURL + strings.TrimPrefix(user.SubscriptionURL, "/")
+}
+
+// accessToken returns a valid token, logging in when there is none or it is
+// about to expire.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Add(tokenMargin).Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {"password"}, "username": {c.username}, "password": {c.password}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"api/admin/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{StatusCode: resp.StatusCode, Message: "login failed"}
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	c.token = token.AccessToken
+	c.tokenExpiry = tokenExpiry(token.AccessToken)
+	return c.token, nil
+}
+
+func (c *Client) dropToken(token string) {
+	c.mu.Lock()
+	if c.token == token {
+		c.token = ""
+	}
+	c.mu.Unlock()
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it. Tokens
+// without one are treated as valid for a day, Marzban's default lifetime.
+func tokenExpiry(token string) time.Time {
+	fallback := time.Now().Add(24 * time.Hour)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fallback
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fallback
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return fallback
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// do sends an authorized request, logging in again once if the token was
+// rejected.
+func (c *Client) do(ctx context.Context, method, path string, body, dest interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		token, err := c.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+		var reader io.Reader
+		if payload != nil {
+			reader = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			resp.Body.Close()
+			c.dropToken(token)
+			continue
+		}
+		err = decode(resp, dest)
+		resp.Body.Close()
+		return err
+	}
+	return &StatusError{StatusCode: http.StatusUnauthorized}
+}
+
+func decode(resp *http.Response, dest interface{}) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var detail struct {
+			Detail interface{} `json:"detail"`
+		}
+		statusErr := &StatusError{StatusCode: resp.StatusCode}
+		if json.NewDecoder(resp.Body).Decode(&detail) == nil && detail.Detail != nil {
+			statusErr.Message = fmt.Sprint(detail.Detail)
+		}
+		return statusErr
+	}
+	if dest == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
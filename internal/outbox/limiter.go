@@ -0,0 +1,66 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter spaces out sends both globally and per chat. The global and
+// per-chat slots are reserved separately, so a chat that has to wait does
+// not delay messages to other chats.
+type Limiter struct {
+	mu           sync.Mutex
+	interval     time.Duration
+	chatInterval time.Duration
+	next         time.Time
+	chatNext     map[int64]time.Time
+}
+
+func NewLimiter(perSecond int, chatInterval time.Duration) *Limiter {
+	return &Limiter{
+		interval:     time.Second / time.Duration(perSecond),
+		chatInterval: chatInterval,
+		chatNext:     make(map[int64]time.Time),
+	}
+}
+
+// Reserve takes the next global slot for a message to chatID and returns
+// when it may be sent. If the chat cannot receive a message yet, nothing is
+// reserved and Reserve returns when the chat is free, and false.
+func (l *Limiter) Reserve(chatID int64) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if t := l.chatNext[chatID]; t.After(now) {
+		return t, false
+	}
+	at := now
+	if l.next.After(at) {
+		at = l.next
+	}
+	l.next = at.Add(l.interval)
+	l.chatNext[chatID] = at.Add(l.chatInterval)
+	if len(l.chatNext) > 10000 {
+		for id, t := range l.chatNext {
+			if t.Before(now) {
+				delete(l.chatNext, id)
+			}
+		}
+	}
+	return at, true
+}
+
+// Wait blocks until a message to chatID may be sent, first for the chat to
+// be free and then for a global slot.
+func (l *Limiter) Wait(ctx context.Context, chatID int64) error {
+	for {
+		at, ok := l.Reserve(chatID)
+		if err := sleep(ctx, time.Until(at)); err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(10, time.Second)
+	start := time.Now()
+
+	first, ok := l.Reserve(1)
+	if !ok || first.Sub(start) > 10*time.Millisecond {
+		t.Fatalf("first Reserve = %v, %v; want now, true", first.Sub(start), ok)
+	}
+
+	// Another chat gets the next global slot.
+	second, ok := l.Reserve(2)
+	if !ok || second.Sub(first) != 100*time.Millisecond {
+		t.Errorf("Reserve for another chat = +%v, %v; want +100ms, true", second.Sub(first), ok)
+	}
+
+	// The same chat waits for its own interval without taking a slot.
+	free, ok := l.Reserve(1)
+	if ok || free != first.Add(time.Second) {
+		t.Errorf("Reserve for a busy chat = +%v, %v; want +1s, false", free.Sub(first), ok)
+	}
+	third, ok := l.Reserve(3)
+	if !ok || third.Sub(second) != 100*time.Millisecond {
+		t.Errorf("Reserve after a busy chat = +%v, %v; want +100ms, true", third.Sub(second), ok)
+	}
+}
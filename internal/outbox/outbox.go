@@ -0,0 +1,275 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"vpn-bot/internal/storage"
+)
+
+const (
+	globalRate   = 30
+	chatInterval = time.Second
+	maxAttempts  = 6
+	maxBackoff   = 10 * time.Minute
+	pollInterval = time.Second
+	batchSize    = 50
+)
+
+// Message is an outgoing text or photo.
+type Message struct {
+	ChatID      int64
+	Text        string
+	PhotoFileID string
+	ParseMode   string
+	Markup      *tgbotapi.InlineKeyboardMarkup
+}
+
+// Outbox persists outgoing messages and delivers them within Telegram's rate
+// limits, retrying transient failures.
+type Outbox struct {
+	api     *tgbotapi.BotAPI
+	store   *storage.Storage
+	limiter *Limiter
+	wake    chan struct{}
+}
+
+func New(api *tgbotapi.BotAPI, store *storage.Storage) *Outbox {
+	return &Outbox{
+		api:     api,
+		store:   store,
+		limiter: NewLimiter(globalRate, chatInterval),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Enqueue stores the message for delivery by Run.
+func (o *Outbox) Enqueue(ctx context.Context, m Message) error {
+	row := &storage.OutgoingMessage{
+		ChatID:      m.ChatID,
+		Text:        sql.NullString{String: m.Text, Valid: m.Text != ""},
+		PhotoFileID: sql.NullString{String: m.PhotoFileID, Valid: m.PhotoFileID != ""},
+		ParseMode:   sql.NullString{String: m.ParseMode, Valid: m.ParseMode != ""},
+	}
+	if m.Markup != nil {
+		data, err := json.Marshal(m.Markup)
+		if err != nil {
+			return err
+		}
+		row.ReplyMarkup = sql.NullString{String: string(data), Valid: true}
+	}
+	if _, err := o.store.EnqueueMessage(ctx, row); err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Send delivers c immediately, waiting for the rate limiter and for flood
+// control if Telegram asks to. It is meant for interactive responses whose
+// result the caller needs, such as edits.
+func (o *Outbox) Send(ctx context.Context, chatID int64, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var sent tgbotapi.Message
+	err := o.call(ctx, chatID, func() (err error) {
+		sent, err = o.api.Send(c)
+		return err
+	})
+	return sent, err
+}
+
+// Request performs a raw Bot API call like Send, for parameters the library
+// has no config for.
+func (o *Outbox) Request(ctx context.Context, chatID int64, endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	var resp *tgbotapi.APIResponse
+	err := o.call(ctx, chatID, func() (err error) {
+		resp, err = o.api.MakeRequest(endpoint, params)
+		return err
+	})
+	return resp, err
+}
+
+func (o *Outbox) call(ctx context.Context, chatID int64, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := o.limiter.Wait(ctx, chatID); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if wait, ok := RetryAfter(err); ok && attempt < 3 {
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+		if IsBlocked(err) {
+			o.deactivate(ctx, chatID)
+		}
+		return err
+	}
+}
+
+// Run delivers queued messages until ctx is cancelled. A message to a chat
+// that cannot receive one yet is put off until it can, so the rest of the
+// batch goes on.
+func (o *Outbox) Run(ctx context.Context) error {
+	for {
+		batch, err := o.store.ListDueMessages(ctx, batchSize)
+		if err != nil {
+			log.Printf("outbox: list due messages: %v", err)
+		}
+		for _, m := range batch {
+			at, ok := o.limiter.Reserve(m.ChatID)
+			if !ok {
+				if err := o.store.DeferMessage(ctx, m.ID, at); err != nil {
+					log.Printf("outbox: defer message %d: %v", m.ID, err)
+				}
+				continue
+			}
+			if err := sleep(ctx, time.Until(at)); err != nil {
+				return err
+			}
+			o.deliver(ctx, m)
+		}
+		if len(batch) == batchSize {
+			continue
+		}
+
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-o.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (o *Outbox) deliver(ctx context.Context, m storage.OutgoingMessage) {
+	c, err := chattable(m)
+	if err != nil {
+		o.fail(ctx, m, "failed", err)
+		return
+	}
+
+	_, err = o.api.Send(c)
+	switch {
+	case err == nil:
+		if err := o.store.MarkMessageSent(ctx, m.ID); err != nil {
+			log.Printf("outbox: mark sent: %v", err)
+		}
+	case IsBlocked(err):
+		o.deactivate(ctx, m.ChatID)
+		o.fail(ctx, m, "blocked", err)
+	default:
+		if wait, ok := RetryAfter(err); ok {
+			o.reschedule(ctx, m, wait, false, err)
+			return
+		}
+		if !isTransient(err) || m.Attempts+1 >= maxAttempts {
+			o.fail(ctx, m, "failed", err)
+			return
+		}
+		o.reschedule(ctx, m, backoff(m.Attempts), true, err)
+	}
+}
+
+func (o *Outbox) reschedule(ctx context.Context, m storage.OutgoingMessage, wait time.Duration, countAttempt bool, cause error) {
+	if err := o.store.RescheduleMessage(ctx, m.ID, time.Now().Add(wait), countAttempt, cause.Error()); err != nil {
+		log.Printf("outbox: reschedule message %d: %v", m.ID, err)
+	}
+}
+
+func (o *Outbox) fail(ctx context.Context, m storage.OutgoingMessage, status string, cause error) {
+	log.Printf("outbox: message %d to %d %s: %v", m.ID, m.ChatID, status, cause)
+	if err := o.store.FailMessage(ctx, m.ID, status, cause.Error()); err != nil {
+		log.Printf("outbox: fail message %d: %v", m.ID, err)
+	}
+}
+
+func (o *Outbox) deactivate(ctx context.Context, chatID int64) {
+	if err := o.store.DeactivateUserByTelegramID(ctx, chatID); err != nil {
+		log.Printf("outbox: deactivate user %d: %v", chatID, err)
+	}
+}
+
+func chattable(m storage.OutgoingMessage) (tgbotapi.Chattable, error) {
+	var markup interface{}
+	if m.ReplyMarkup.Valid {
+		var kb tgbotapi.InlineKeyboardMarkup
+		if err := json.Unmarshal([]byte(m.ReplyMarkup.String), &kb); err != nil {
+			return nil, err
+		}
+		markup = kb
+	}
+
+	if m.PhotoFileID.Valid {
+		photo := tgbotapi.NewPhoto(m.ChatID, tgbotapi.FileID(m.PhotoFileID.String))
+		photo.Caption = m.Text.String
+		photo.ParseMode = m.ParseMode.String
+		photo.ReplyMarkup = markup
+		return photo, nil
+	}
+	msg := tgbotapi.NewMessage(m.ChatID, m.Text.String)
+	msg.ParseMode = m.ParseMode.String
+	msg.ReplyMarkup = markup
+	return msg, nil
+}
+
+// IsBlocked reports whether Telegram refused delivery because the user
+// blocked the bot or deleted their account.
+func IsBlocked(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusForbidden
+}
+
+// RetryAfter returns the flood control wait requested by Telegram.
+func RetryAfter(err error) (time.Duration, bool) {
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) && tgErr.Code == http.StatusTooManyRequests && tgErr.RetryAfter > 0 {
+		return time.Duration(tgErr.RetryAfter) * time.Second, true
+	}
+	return 0, false
+}
+
+// isTransient treats network failures and server-side errors as retryable.
+// Other API errors (bad request, chat not found) will not succeed on retry.
+func isTransient(err error) bool {
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		return tgErr.Code >= 500 || tgErr.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func backoff(attempts int) time.Duration {
+	d := time.Second << attempts
+	if d <= 0 || d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+// Package outline is a client for the Outline server management API.
+package outline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for access keys the server does not have.
+var ErrNotFound = errors.New("outline: access key not found")
+
+// StatusError is an error answer of the server other than not found.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("outline request failed: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("outline request failed: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+type Client struct {
+	apiURL     string
+	httpClient *http.Client
+}
+
+// AccessKey is an Outline access key. DataLimit is nil for keys without a
+// limit.
+type AccessKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	AccessURL string     `json:"accessUrl"`
+	DataLimit *DataLimit `json:"dataLimit,omitempty"`
+}
+
+type DataLimit struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// New creates a client for the management API at apiURL, which includes the
+// server's secret path. The management API uses a self-signed certificate;
+// when certSHA256 (the hex SHA-256 of the certificate, as printed by the
+// Outline installer) is set, only that certificate is accepted.
+func New(apiURL, certSHA256 string) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if certSHA256 != "" {
+		want, err := hex.DecodeString(strings.ReplaceAll(certSHA256, ":", ""))
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate fingerprint %q", certSHA256)
+		}
+		transport.TLSClientConfig = &tls.Config{
+			// The certificate is checked against the fingerprint instead of
+			// a CA.
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 {
+					return errors.New("outline: no server certificate")
+				}
+				got := sha256.Sum256(rawCerts[0])
+				if !bytes.Equal(got[:], want) {
+					return fmt.Errorf("outline: certificate fingerprint mismatch: %X", got)
+				}
+				return nil
+			},
+		}
+	}
+	return &Client{
+		apiURL:     strings.TrimSuffix(apiURL, "/") + "/",
+		httpClient: &http.Client{Timeout: 15 * time.Second, Transport: transport},
+	}, nil
+}
+
+func (c *Client) CreateAccessKey(ctx context.Context) (*AccessKey, error) {
+	var key AccessKey
+	if err := c.do(ctx, http.MethodPost, "access-keys", nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (c *Client) ListAccessKeys(ctx context.Context) ([]AccessKey, error) {
+	var resp struct {
+		AccessKeys []AccessKey `json:"accessKeys"`
+	}
+	if err := c.do(ctx, http.MethodGet, "access-keys", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.AccessKeys, nil
+}
+
+// GetAccessKey looks the key up in the list, since older servers have no
+// endpoint for a single key.
+func (c *Client) GetAccessKey(ctx context.Context, id string) (*AccessKey, error) {
+	keys, err := c.ListAccessKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.ID == id {
+			return &k, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (c *Client) RenameAccessKey(ctx context.Context, id, name string) error {
+	return c.do(ctx, http.MethodPut, "access-keys/"+url.PathEscape(id)+"/name", map[string]string{"name": name}, nil)
+}
+
+// SetDataLimit limits the key's traffic. A zero limit blocks the key.
+func (c *Client) SetDataLimit(ctx context.Context, id string, bytes int64) error {
+	body := map[string]DataLimit{"limit": {Bytes: bytes}}
+	return c.do(ctx, http.MethodPut, "access-keys/"+url.PathEscape(id)+"/data-limit", body, nil)
+}
+
+func (c *Client) RemoveDataLimit(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "access-keys/"+url.PathEscape(id)+"/data-limit", nil, nil)
+}
+
+func (c *Client) DeleteAccessKey(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "access-keys/"+url.PathEscape(id), nil, nil)
+}
+
+// TransferMetrics returns the bytes transferred by each key over the last
+// 30 days, by key ID.
+func (c *Client) TransferMetrics(ctx context.Context) (map[string]int64, error) {
+	var resp struct {
+		BytesTransferredByUserID map[string]int64 `json:"bytesTransferredByUserId"`
+	}
+	if err := c.do(ctx, http.MethodGet, "metrics/transfer", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.BytesTransferredByUserID, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, dest interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		statusErr := &StatusError{StatusCode: resp.StatusCode}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil {
+			statusErr.Message = apiErr.Message
+		}
+		return statusErr
+	}
+	if dest == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
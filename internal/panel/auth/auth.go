@@ -2,64 +2,281 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type loginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	TwoFactorCode string `json:"twoFactorCode,omitempty"`
+}
+
+type loginResponse struct {
+	Success bool   `json:"success"`
+	Msg     string `json:"msg"`
 }
 
 var (
-	cfgMu    sync.RWMutex
-	baseURL  string
-	username string
-	password string
+	// ErrBadCredentials is returned when the panel rejects the username or
+	// password.
+	ErrBadCredentials = errors.New("auth: invalid username or password")
+	// ErrBadOTP is returned when the panel rejects the two-factor code, or
+	// requires one and no TOTP secret is configured.
+	ErrBadOTP = errors.New("auth: invalid two-factor code")
+	// ErrBadLogin is returned when the panel rejects the login with one
+	// message for the credentials and the two-factor code, as 3x-ui does.
+	ErrBadLogin = errors.New("auth: invalid username, password or two-factor code")
+)
+
+const (
+	// refreshMargin is how long before the session cookie expires it is
+	// renewed.
+	refreshMargin = 5 * time.Minute
 
-	httpClient = &http.Client{Timeout: 15 * time.Second}
+	minLoginBackoff = time.Second
+	maxLoginBackoff = 5 * time.Minute
 )
 
-// Configure sets credentials that will be used for subsequent login requests.
-func Configure(url, user, pass string) {
-	cfgMu.Lock()
-	defer cfgMu.Unlock()
+// Credentials are the login details of a panel.
+type Credentials struct {
+	URL      string
+	Username string
+	Password string
+	// TOTPSecret is the base32 secret of a panel with two-factor login; a
+	// code is generated from it at each login.
+	TOTPSecret string
+	// SessionKey encrypts the sessions saved in the SessionStore. Without
+	// it sessions are kept in memory only.
+	SessionKey string
+}
 
-	baseURL = url
-	username = user
-	password = pass
+// SessionStore keeps sessions across restarts. Saved cookies carry their
+// absolute expiry in Expires, zero if unknown.
+type SessionStore interface {
+	LoadPanelSession(ctx context.Context, panelURL string) (*http.Cookie, error)
+	SavePanelSession(ctx context.Context, panelURL string, c *http.Cookie) error
 }
 
-// LoginAndGetSession performs a login request against the configured panel and
-// returns the authenticated session cookie.
-func LoginAndGetSession() (*http.Cookie, error) {
-	cfgMu.RLock()
-	url := baseURL
-	user := username
-	pass := password
-	cfgMu.RUnlock()
+// SessionAuth authenticates with the session cookie of a username and
+// password login. Concurrent logins are merged into one, the session is
+// renewed shortly before the cookie expires, and failed logins are retried
+// with a growing delay so a wrong password does not hammer the panel.
+type SessionAuth struct {
+	url      string
+	username string
+	password string
+	totpKey  []byte
+
+	httpClient *http.Client
+	group      singleflight.Group
+	store      SessionStore
+
+	mu        sync.RWMutex
+	session   *http.Cookie
+	expiresAt time.Time
+
+	// lastErr and retryAt hold the last failed login and when the next one
+	// is allowed.
+	lastErr error
+	retryAt time.Time
+	backoff time.Duration
+}
 
-	if url == "" || user == "" || pass == "" {
+// NewSessionAuth creates a session authenticator. Sessions are saved to
+// store, encrypted with creds.SessionKey; store may be nil, and is unused
+// without a key, to keep sessions in memory only.
+func NewSessionAuth(creds Credentials, store SessionStore) (*SessionAuth, error) {
+	if creds.URL == "" || creds.Username == "" || creds.Password == "" {
 		return nil, errors.New("auth: credentials are not configured")
 	}
+	a := &SessionAuth{
+		url:        creds.URL,
+		username:   creds.Username,
+		password:   creds.Password,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+	if store != nil && creds.SessionKey != "" {
+		sealed, err := newSealedStore(store, creds.SessionKey)
+		if err != nil {
+			return nil, err
+		}
+		a.store = sealed
+	}
+	if creds.TOTPSecret != "" {
+		key, err := decodeTOTPSecret(creds.TOTPSecret)
+		if err != nil {
+			return nil, err
+		}
+		a.totpKey = key
+	}
+	return a, nil
+}
 
-	payload := loginRequest{Username: user, Password: pass}
+// Restore loads the saved session and reports whether it is still valid.
+// A session without a known expiry is reused until the panel rejects it.
+func (a *SessionAuth) Restore(ctx context.Context) (bool, error) {
+	if a.store == nil {
+		return false, nil
+	}
+	c, err := a.store.LoadPanelSession(ctx, a.url)
+	if err != nil || c == nil {
+		return false, err
+	}
+	if !c.Expires.IsZero() && time.Until(c.Expires) < refreshMargin {
+		return false, nil
+	}
+	a.mu.Lock()
+	a.session = &http.Cookie{Name: c.Name, Value: c.Value}
+	a.expiresAt = c.Expires
+	a.mu.Unlock()
+	return true, nil
+}
+
+// LoginInBackground logs in until it succeeds or ctx is done, waiting out
+// the login backoff between attempts. It is used when the panel is down at
+// startup.
+func (a *SessionAuth) LoginInBackground(ctx context.Context) {
+	for {
+		_, err := a.Login(ctx)
+		if err == nil {
+			log.Printf("panel login succeeded")
+			return
+		}
+		log.Printf("panel login: %v", err)
+
+		a.mu.RLock()
+		wait := time.Until(a.retryAt)
+		a.mu.RUnlock()
+		if wait < minLoginBackoff {
+			wait = minLoginBackoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Authorize adds the session cookie to req, logging in first if there is
+// no session or it is about to expire.
+func (a *SessionAuth) Authorize(req *http.Request) error {
+	session, expiresAt := a.current()
+	if session == nil || (!expiresAt.IsZero() && time.Until(expiresAt) < refreshMargin) {
+		fresh, err := a.Login(req.Context())
+		switch {
+		case err == nil:
+			session = fresh
+		case session != nil && time.Now().Before(expiresAt):
+			// The old session is still usable.
+			log.Printf("panel session refresh: %v", err)
+		default:
+			return err
+		}
+	}
+	req.AddCookie(session)
+	return nil
+}
+
+// Refresh logs in again after the panel rejected req, unless the session
+// was already renewed since req was sent.
+func (a *SessionAuth) Refresh(ctx context.Context, rejected *http.Request) error {
+	session, _ := a.current()
+	if session != nil {
+		if sent, err := rejected.Cookie(session.Name); err == nil && sent.Value != session.Value {
+			return nil
+		}
+	}
+	_, err := a.Login(ctx)
+	return err
+}
+
+// Login logs in to the panel and returns the new session cookie. Callers
+// arriving while a login is in flight share its result.
+func (a *SessionAuth) Login(ctx context.Context) (*http.Cookie, error) {
+	ch := a.group.DoChan("login", func() (interface{}, error) {
+		// The login is shared, so one caller giving up must not cancel it.
+		return a.login(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*http.Cookie), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (a *SessionAuth) current() (*http.Cookie, time.Time) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.session, a.expiresAt
+}
+
+func (a *SessionAuth) login(ctx context.Context) (*http.Cookie, error) {
+	a.mu.RLock()
+	retryAt, lastErr := a.retryAt, a.lastErr
+	a.mu.RUnlock()
+	if lastErr != nil && time.Now().Before(retryAt) {
+		return nil, fmt.Errorf("auth: login paused until %s: %w", retryAt.Format(time.TimeOnly), lastErr)
+	}
+
+	cookie, err := a.doLogin(ctx)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		if a.backoff == 0 {
+			a.backoff = minLoginBackoff
+		} else if a.backoff < maxLoginBackoff {
+			a.backoff = min(a.backoff*2, maxLoginBackoff)
+		}
+		a.lastErr = err
+		a.retryAt = time.Now().Add(a.backoff)
+		return nil, err
+	}
+	a.lastErr, a.backoff = nil, 0
+	a.session = cookie
+	a.expiresAt = cookieExpiry(cookie)
+	if a.store != nil {
+		saved := &http.Cookie{Name: cookie.Name, Value: cookie.Value, Expires: a.expiresAt}
+		if err := a.store.SavePanelSession(ctx, a.url, saved); err != nil {
+			log.Printf("save panel session: %v", err)
+		}
+	}
+	return cookie, nil
+}
+
+func (a *SessionAuth) doLogin(ctx context.Context) (*http.Cookie, error) {
+	payload := loginRequest{Username: a.username, Password: a.password}
+	if a.totpKey != nil {
+		payload.TwoFactorCode = totpCode(a.totpKey, time.Now())
+	}
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
 		return nil, fmt.Errorf("auth: encode login payload: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url+"login", &buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"login", &buf)
 	if err != nil {
 		return nil, fmt.Errorf("auth: new request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("auth: do request: %w", err)
 	}
@@ -69,6 +286,12 @@ func LoginAndGetSession() (*http.Cookie, error) {
 		return nil, fmt.Errorf("auth: login failed: %s", resp.Status)
 	}
 
+	// 3x-ui answers failed logins with 200 and success false.
+	var result loginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && !result.Success {
+		return nil, fmt.Errorf("%w: %s", loginFailure(result.Msg), result.Msg)
+	}
+
 	cookies := resp.Cookies()
 	if len(cookies) == 0 {
 		return nil, errors.New("auth: session cookie not found")
@@ -83,3 +306,41 @@ func LoginAndGetSession() (*http.Cookie, error) {
 	// Fall back to the first cookie if the panel uses a different name.
 	return cookies[0], nil
 }
+
+// cookieExpiry returns when c expires, or zero for a cookie without an
+// expiry, which is only renewed when the panel rejects it.
+func cookieExpiry(c *http.Cookie) time.Time {
+	if c.MaxAge > 0 {
+		return time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+	}
+	return c.Expires
+}
+
+// loginFailure classifies a login failure message: ErrBadOTP if it names
+// only the two-factor code, ErrBadLogin if it names the code along with the
+// username or password, and ErrBadCredentials otherwise.
+func loginFailure(msg string) error {
+	var otp, credentials bool
+	words := strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		switch w {
+		case "2fa", "otp", "totp", "twofactor":
+			otp = true
+		case "two":
+			if i+1 < len(words) && words[i+1] == "factor" {
+				otp = true
+			}
+		case "username", "password", "credentials":
+			credentials = true
+		}
+	}
+	switch {
+	case otp && credentials:
+		return ErrBadLogin
+	case otp:
+		return ErrBadOTP
+	}
+	return ErrBadCredentials
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLoginFailure(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want error
+	}{
+		{"Invalid username or password", ErrBadCredentials},
+		{"Wrong credentials", ErrBadCredentials},
+		{"", ErrBadCredentials},
+		{"Invalid 2FA code", ErrBadOTP},
+		{"two-factor code is wrong", ErrBadOTP},
+		{"Invalid TOTP", ErrBadOTP},
+		{"Invalid username or password or two-factor code", ErrBadLogin},
+		{"wrong password/2fa", ErrBadLogin},
+		// "two" alone does not name the code.
+		{"two attempts left, check the password", ErrBadCredentials},
+	}
+	for _, tt := range tests {
+		if got := loginFailure(tt.msg); !errors.Is(got, tt.want) {
+			t.Errorf("loginFailure(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrTokenRejected is returned when the panel rejects a static API token,
+// which cannot be renewed.
+var ErrTokenRejected = errors.New("auth: panel rejected the API token")
+
+// Authenticator adds credentials to panel requests.
+type Authenticator interface {
+	// Authorize sets the credentials on req.
+	Authorize(req *http.Request) error
+	// Refresh renews the credentials after the panel rejected the ones sent
+	// with rejected.
+	Refresh(ctx context.Context, rejected *http.Request) error
+}
+
+// TokenAuth authenticates with a bearer API token, so the panel password
+// does not have to be stored.
+type TokenAuth struct {
+	token string
+}
+
+func NewTokenAuth(token string) *TokenAuth {
+	return &TokenAuth{token: token}
+}
+
+func (a *TokenAuth) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *TokenAuth) Refresh(ctx context.Context, rejected *http.Request) error {
+	return ErrTokenRejected
+}
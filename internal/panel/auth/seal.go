@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// sealedStore encrypts the cookie values kept by a SessionStore with
+// AES-GCM, so that reading the database does not give access to the panel.
+// The panel URL and cookie name are authenticated along with the value.
+type sealedStore struct {
+	store SessionStore
+	aead  cipher.AEAD
+}
+
+// newSealedStore derives the encryption key from secret with SHA-256.
+func newSealedStore(store SessionStore, secret string) (*sealedStore, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("auth: session cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("auth: session cipher: %w", err)
+	}
+	return &sealedStore{store: store, aead: aead}, nil
+}
+
+// LoadPanelSession returns nil for a session that cannot be decrypted, such
+// as one saved under another key, so that the panel is logged in to again.
+func (s *sealedStore) LoadPanelSession(ctx context.Context, panelURL string) (*http.Cookie, error) {
+	c, err := s.store.LoadPanelSession(ctx, panelURL)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	value, err := s.open(panelURL, c.Name, c.Value)
+	if err != nil {
+		log.Printf("saved panel session: %v", err)
+		return nil, nil
+	}
+	c.Value = value
+	return c, nil
+}
+
+func (s *sealedStore) SavePanelSession(ctx context.Context, panelURL string, c *http.Cookie) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("auth: session nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(c.Value), additionalData(panelURL, c.Name))
+	saved := *c
+	saved.Value = base64.StdEncoding.EncodeToString(sealed)
+	return s.store.SavePanelSession(ctx, panelURL, &saved)
+}
+
+func (s *sealedStore) open(panelURL, name, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("auth: session is not encrypted")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, additionalData(panelURL, name))
+	if err != nil {
+		return "", errors.New("auth: session cannot be decrypted with PANEL_SESSION_KEY")
+	}
+	return string(plain), nil
+}
+
+func additionalData(panelURL, name string) []byte {
+	return []byte(panelURL + "\x00" + name)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// memoryStore is a SessionStore keeping cookies as saved.
+type memoryStore map[string]http.Cookie
+
+func (m memoryStore) LoadPanelSession(_ context.Context, panelURL string) (*http.Cookie, error) {
+	c, ok := m[panelURL]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (m memoryStore) SavePanelSession(_ context.Context, panelURL string, c *http.Cookie) error {
+	m[panelURL] = *c
+	return nil
+}
+
+func TestSealedStore(t *testing.T) {
+	const url = "https://panel.example.com/"
+	ctx := context.Background()
+	raw := memoryStore{}
+	s, err := newSealedStore(raw, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Unix(1700000000, 0)
+	if err := s.SavePanelSession(ctx, url, &http.Cookie{Name: "session", Value: "abc", Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if v := raw[url].Value; v == "abc" || v == "" {
+		t.Fatalf("saved value = %q, want it encrypted", v)
+	}
+
+	c, err := s.LoadPanelSession(ctx, url)
+	if err != nil || c == nil || c.Name != "session" || c.Value != "abc" || !c.Expires.Equal(expires) {
+		t.Fatalf("LoadPanelSession = %+v, %v; want the saved session", c, err)
+	}
+
+	other, _ := newSealedStore(raw, "other secret")
+	if c, err := other.LoadPanelSession(ctx, url); c != nil || err != nil {
+		t.Errorf("LoadPanelSession with another key = %+v, %v; want nil, nil", c, err)
+	}
+	if c, err := s.LoadPanelSession(ctx, "https://other.example.com/"); c != nil || err != nil {
+		t.Errorf("LoadPanelSession of an unknown panel = %+v, %v; want nil, nil", c, err)
+	}
+
+	// Sessions saved before encryption are dropped.
+	raw[url] = http.Cookie{Name: "session", Value: "abc"}
+	if c, err := s.LoadPanelSession(ctx, url); c != nil || err != nil {
+		t.Errorf("LoadPanelSession of a plaintext session = %+v, %v; want nil, nil", c, err)
+	}
+}
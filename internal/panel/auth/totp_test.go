@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 vectors of RFC 6238, appendix B, truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	// "12345678901234567890" in base32.
+	const want = "12345678901234567890"
+	tests := []struct {
+		secret string
+		ok     bool
+	}{
+		{"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", true},
+		{"gezdgnbvgy3tqojqgezdgnbvgy3tqojq", true},
+		{"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ", true},
+		{"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ====", true},
+		{"", false},
+		{"not base32!", false},
+	}
+	for _, tt := range tests {
+		key, err := decodeTOTPSecret(tt.secret)
+		if (err == nil) != tt.ok {
+			t.Errorf("decodeTOTPSecret(%q) error = %v, want ok %v", tt.secret, err, tt.ok)
+			continue
+		}
+		if tt.ok && string(key) != want {
+			t.Errorf("decodeTOTPSecret(%q) = %q, want %q", tt.secret, key, want)
+		}
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"sync"
+	"net/url"
 	"time"
 
 	"vpn-bot/internal/panel/auth"
@@ -17,20 +19,47 @@ type Client struct {
 	baseURL    string
 	httpClient *http.Client
 
-	mu      sync.RWMutex
-	session *http.Cookie
-
+	auth auth.Authenticator
 }
 
 type AddClientRequest struct {
 	ID      int    `json:"id"`
 	Email   string `json:"email"`
 	LimitIP int    `json:"limitIp"`
-	TotalGB int    `json:"totalGB"`
+	TotalGB int64  `json:"totalGB"`
 	Expiry  int64  `json:"expiryTime"`
 	Enable  bool   `json:"enable"`
 }
 
+// ClientOptions are the limits of a new panel client. Zero values mean
+// the defaults of AddClient.
+type ClientOptions struct {
+	Email   string
+	LimitIP int
+	// TotalBytes is the traffic limit; zero means unlimited.
+	TotalBytes int64
+	Expiry     time.Time
+	// NoExpiry creates a client that never expires instead of defaulting
+	// a zero Expiry.
+	NoExpiry bool
+}
+
+// ClientInfo is the state of a panel client.
+type ClientInfo struct {
+	ID     string
+	Email  string
+	Enable bool
+	// Expiry is zero for clients that never expire.
+	Expiry time.Time
+	// LimitIP is only filled in by ListClients.
+	LimitIP    int
+	TotalBytes int64
+	Up         int64
+	Down       int64
+	// CreatedAt is zero when the panel does not report it.
+	CreatedAt time.Time
+}
+
 type AddClientResponse struct {
 	Success bool   `json:"success"`
 	Msg     string `json:"msg"`
@@ -41,7 +70,8 @@ type AddClientResponse struct {
 
 type UpdateClientRequest struct {
 	ID        string `json:"id"`
-	Expiry    int64  `json:"expiryTime"`
+	Expiry    int64  `json:"expiryTime,omitempty"`
+	Enable    *bool  `json:"enable,omitempty"`
 	Operation string `json:"operation"`
 }
 
@@ -55,43 +85,103 @@ type TrafficResponse struct {
 	Msg     string `json:"msg"`
 	Obj     []struct {
 		ID     string `json:"id"`
+		Email  string `json:"email"`
 		Expiry int64  `json:"expiryTime"`
 		Enable bool   `json:"enable"`
+		Total  int64  `json:"total"`
+		Up     int64  `json:"up"`
+		Down   int64  `json:"down"`
+	} `json:"obj"`
+}
+
+type InboundsResponse struct {
+	Success bool   `json:"success"`
+	Msg     string `json:"msg"`
+	Obj     []struct {
+		ID       int    `json:"id"`
+		Settings string `json:"settings"`
 	} `json:"obj"`
 }
 
-func New(baseURL string, session *http.Cookie) *Client {
+type inboundSettings struct {
+	Clients []struct {
+		ID      string `json:"id"`
+		Email   string `json:"email"`
+		LimitIP int    `json:"limitIp"`
+		TotalGB int64  `json:"totalGB"`
+		Expiry  int64  `json:"expiryTime"`
+		Enable  bool   `json:"enable"`
+		// CreatedAt is in milliseconds; older panels do not send it.
+		CreatedAt int64 `json:"created_at"`
+	} `json:"clients"`
+}
+
+func New(baseURL string, authenticator auth.Authenticator) *Client {
 	return &Client{
 		baseURL:    baseURL,
 		httpClient: &http.Client{Timeout: 15 * time.Second},
-		session:    session,
-
+		auth:       authenticator,
 	}
 }
 
 func (c *Client) AddClient(ctx context.Context, userID int) (string, error) {
+	return c.CreateClient(ctx, userID, ClientOptions{})
+}
+
+// CreateClient adds a panel client with the given limits. The panel requires
+// unique emails, so clients replacing an existing one need their own.
+func (c *Client) CreateClient(ctx context.Context, userID int, opts ClientOptions) (string, error) {
+	if opts.Email == "" {
+		opts.Email = fmt.Sprintf("user-%d@example.com", userID)
+	}
+	if opts.LimitIP == 0 {
+		opts.LimitIP = 1
+	}
+	if opts.Expiry.IsZero() && !opts.NoExpiry {
+		opts.Expiry = time.Now().Add(30 * 24 * time.Hour)
+	}
 	reqBody := AddClientRequest{
 		ID:      userID,
-		Email:   fmt.Sprintf("user-%d@example.com", userID),
-		LimitIP: 1,
-		TotalGB: 0,
-		Expiry:  time.Now().Add(30 * 24 * time.Hour).Unix(),
+		Email:   opts.Email,
+		LimitIP: opts.LimitIP,
+		TotalGB: opts.TotalBytes,
 		Enable:  true,
 	}
+	if !opts.Expiry.IsZero() {
+		reqBody.Expiry = opts.Expiry.Unix()
+	}
 	var resp AddClientResponse
 	if err := c.do(ctx, http.MethodPost, "xui/inbound/addClient", reqBody, &resp); err != nil {
 		return "", err
 	}
 	if !resp.Success {
-		return "", fmt.Errorf("panel error: %s", resp.Msg)
+		return "", &APIError{StatusCode: http.StatusOK, Message: resp.Msg}
 	}
 	return resp.Obj.ID, nil
 }
 
 func (c *Client) UpdateClient(ctx context.Context, keyID string, days int) error {
+	return c.SetClientExpiry(ctx, keyID, time.Now().Add(time.Duration(days)*24*time.Hour))
+}
+
+// SetClientExpiry sets the client's expiry to an absolute time and enables
+// it.
+func (c *Client) SetClientExpiry(ctx context.Context, keyID string, expiry time.Time) error {
+	enable := true
+	reqBody := UpdateClientRequest{
+		ID:        keyID,
+		Expiry:    expiry.Unix(),
+		Enable:    &enable,
+		Operation: "update",
+	}
+	return c.postGeneric(ctx, "xui/inbound/updateClient", reqBody)
+}
+
+func (c *Client) DisableClient(ctx context.Context, keyID string) error {
+	enable := false
 	reqBody := UpdateClientRequest{
 		ID:        keyID,
-		Expiry:    time.Now().Add(time.Duration(days) * 24 * time.Hour).Unix(),
+		Enable:    &enable,
 		Operation: "update",
 	}
 	return c.postGeneric(ctx, "xui/inbound/updateClient", reqBody)
@@ -103,14 +193,82 @@ func (c *Client) DelClient(ctx context.Context, keyID string) error {
 }
 
 func (c *Client) GetClientStatus(ctx context.Context, keyID string) (time.Time, error) {
+	info, err := c.GetClient(ctx, keyID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.Expiry, nil
+}
+
+func (c *Client) GetClient(ctx context.Context, keyID string) (*ClientInfo, error) {
 	var resp TrafficResponse
 	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("xui/inbound/getClientTraffics?id=%s", keyID), nil, &resp); err != nil {
-		return time.Time{}, err
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, &APIError{StatusCode: http.StatusOK, Message: resp.Msg}
+	}
+	if len(resp.Obj) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, keyID)
+	}
+	obj := resp.Obj[0]
+	info := &ClientInfo{
+		ID:         obj.ID,
+		Email:      obj.Email,
+		Enable:     obj.Enable,
+		TotalBytes: obj.Total,
+		Up:         obj.Up,
+		Down:       obj.Down,
+	}
+	if obj.Expiry > 0 {
+		info.Expiry = time.Unix(obj.Expiry, 0)
+	}
+	return info, nil
+}
+
+// ClientLimitIP returns the client's IP limit, zero if unlimited. The
+// traffic endpoint behind GetClient leaves it out, so the inbounds are read.
+func (c *Client) ClientLimitIP(ctx context.Context, keyID string) (int, error) {
+	clients, err := c.ListClients(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, cl := range clients {
+		if cl.ID == keyID {
+			return cl.LimitIP, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrNotFound, keyID)
+}
+
+// ListClients returns the clients of all inbounds. Traffic counters are not
+// filled in.
+func (c *Client) ListClients(ctx context.Context) ([]ClientInfo, error) {
+	var resp InboundsResponse
+	if err := c.do(ctx, http.MethodGet, "xui/inbound/list", nil, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, &APIError{StatusCode: http.StatusOK, Message: resp.Msg}
 	}
-	if !resp.Success || len(resp.Obj) == 0 {
-		return time.Time{}, fmt.Errorf("client not found: %s", resp.Msg)
+	var clients []ClientInfo
+	for _, inbound := range resp.Obj {
+		var settings inboundSettings
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			return nil, fmt.Errorf("inbound %d settings: %w", inbound.ID, err)
+		}
+		for _, cl := range settings.Clients {
+			info := ClientInfo{ID: cl.ID, Email: cl.Email, Enable: cl.Enable, LimitIP: cl.LimitIP, TotalBytes: cl.TotalGB}
+			if cl.Expiry > 0 {
+				info.Expiry = time.Unix(cl.Expiry, 0)
+			}
+			if cl.CreatedAt > 0 {
+				info.CreatedAt = time.UnixMilli(cl.CreatedAt)
+			}
+			clients = append(clients, info)
+		}
 	}
-	return time.Unix(resp.Obj[0].Expiry, 0), nil
+	return clients, nil
 }
 
 func (c *Client) postGeneric(ctx context.Context, path string, body interface{}) error {
@@ -119,11 +277,13 @@ func (c *Client) postGeneric(ctx context.Context, path string, body interface{})
 		return err
 	}
 	if !resp.Success {
-		return fmt.Errorf("panel error: %s", resp.Msg)
+		return &APIError{StatusCode: http.StatusOK, Message: resp.Msg}
 	}
 	return nil
 }
 
+// do sends a request, logging in again if the session was rejected.
+// Network and server errors wrap ErrUnavailable.
 func (c *Client) do(ctx context.Context, method, path string, body interface{}, dest interface{}) error {
 	var payload []byte
 	if body != nil {
@@ -134,9 +294,12 @@ func (c *Client) do(ctx context.Context, method, path string, body interface{},
 			return err
 		}
 	}
+	return c.send(ctx, method, path, payload, dest)
+}
 
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, dest interface{}) error {
 	for attempt := 0; attempt < 2; attempt++ {
-		var reqBody *bytes.Reader
+		var reqBody io.Reader
 		if payload != nil {
 			reqBody = bytes.NewReader(payload)
 		}
@@ -145,30 +308,31 @@ func (c *Client) do(ctx context.Context, method, path string, body interface{},
 		if err != nil {
 			return err
 		}
-		if body != nil {
+		if payload != nil {
 			req.Header.Set("Content-Type", "application/json")
 		}
 
-		if session := c.getSession(); session != nil {
-			req.AddCookie(session)
+		if err := c.auth.Authorize(req); err != nil {
+			return loginError(err)
 		}
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
 		}
 
 		if resp.StatusCode == http.StatusUnauthorized {
 			resp.Body.Close()
-			if err := c.refreshSession(); err != nil {
-				return err
+			if err := c.auth.Refresh(ctx, req); err != nil {
+				return loginError(err)
 			}
 			continue
 		}
 
 		if resp.StatusCode >= 300 {
+			// Server errors match ErrUnavailable.
 			resp.Body.Close()
-			return fmt.Errorf("panel request failed: %s", resp.Status)
+			return &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
 		}
 
 		if dest != nil {
@@ -181,23 +345,19 @@ func (c *Client) do(ctx context.Context, method, path string, body interface{},
 		return nil
 	}
 
-	return fmt.Errorf("panel request failed: unauthorized")
-}
-
-func (c *Client) getSession() *http.Cookie {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.session
+	return &APIError{StatusCode: http.StatusUnauthorized, Message: "session rejected after login"}
 }
 
-func (c *Client) refreshSession() error {
-	cookie, err := auth.LoginAndGetSession()
-	if err != nil {
-		return err
+// loginError classifies a failed login: unavailable if the panel could not
+// be reached, unauthorized if it rejected the credentials.
+func loginError(err error) error {
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &urlErr):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.Is(err, auth.ErrBadCredentials), errors.Is(err, auth.ErrBadOTP), errors.Is(err, auth.ErrBadLogin),
+		errors.Is(err, auth.ErrTokenRejected):
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
 	}
-	c.mu.Lock()
-	c.session = cookie
-	c.mu.Unlock()
-
-	return nil
+	return err
 }
@@ -0,0 +1,49 @@
+package panel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned for clients the panel does not have.
+	ErrNotFound = errors.New("panel: client not found")
+	// ErrDuplicate is returned when a client with the same email or ID
+	// already exists.
+	ErrDuplicate = errors.New("panel: duplicate client")
+	// ErrUnauthorized is returned when the panel rejects the bot's
+	// credentials.
+	ErrUnauthorized = errors.New("panel: unauthorized")
+	// ErrUnavailable is returned when the panel cannot be reached or fails
+	// with a server error, and by provision.Pool while a server's circuit
+	// breaker is open.
+	ErrUnavailable = errors.New("panel: unavailable")
+)
+
+// APIError is an error answer of the panel: an HTTP error status, or a
+// response with success false, which 3x-ui sends with status 200. It
+// matches the sentinel errors above with errors.Is.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("panel error (%d): %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	msg := strings.ToLower(e.Message)
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == 404 || strings.Contains(msg, "not found")
+	case ErrDuplicate:
+		return e.StatusCode == 409 || strings.Contains(msg, "duplicate") || strings.Contains(msg, "already exists")
+	case ErrUnauthorized:
+		return e.StatusCode == 401 || e.StatusCode == 403
+	case ErrUnavailable:
+		return e.StatusCode >= 500
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package panel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClientIP is an address a client connected from. SeenAt is zero when the
+// panel does not record when.
+type ClientIP struct {
+	IP     string
+	SeenAt time.Time
+}
+
+type onlinesResponse struct {
+	Success bool     `json:"success"`
+	Msg     string   `json:"msg"`
+	Obj     []string `json:"obj"`
+}
+
+type clientIPsResponse struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+// clientIPTimeLayout is how 3x-ui timestamps entries of the IP log.
+const clientIPTimeLayout = "2006-01-02 15:04:05"
+
+// OnlineEmails returns the emails of the clients connected now.
+func (c *Client) OnlineEmails(ctx context.Context) ([]string, error) {
+	var resp onlinesResponse
+	if err := c.do(ctx, http.MethodPost, "xui/inbound/onlines", nil, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, &APIError{StatusCode: http.StatusOK, Message: resp.Msg}
+	}
+	return resp.Obj, nil
+}
+
+// ClientIPs returns the addresses the client with the email recently
+// connected from. It needs IP limits enabled on the panel.
+func (c *Client) ClientIPs(ctx context.Context, email string) ([]ClientIP, error) {
+	var resp clientIPsResponse
+	if err := c.do(ctx, http.MethodPost, "xui/inbound/clientIps/"+url.PathEscape(email), nil, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, &APIError{StatusCode: http.StatusOK, Message: resp.Msg}
+	}
+	return parseClientIPs(resp.Obj), nil
+}
+
+// parseClientIPs reads the IP log, which 3x-ui returns as a list, as the
+// text of a JSON list, or as a message such as "No IP Record". Entries are
+// "<ip>" or "<ip> (<time>)".
+func parseClientIPs(raw json.RawMessage) []ClientIP {
+	var entries []string
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(text), &entries); err != nil {
+			entries = strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' })
+		}
+	}
+
+	var ips []ClientIP
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		addr, rest, _ := strings.Cut(e, " ")
+		if !looksLikeIP(addr) {
+			continue
+		}
+		ip := ClientIP{IP: addr}
+		if ts := strings.Trim(strings.TrimSpace(rest), "()"); ts != "" {
+			if t, err := time.ParseInLocation(clientIPTimeLayout, ts, time.Local); err == nil {
+				ip.SeenAt = t
+			}
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// looksLikeIP filters out messages such as "No IP Record".
+func looksLikeIP(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789abcdefABCDEF.:") == ""
+}
@@ -0,0 +1,36 @@
+package panel
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseClientIPs(t *testing.T) {
+	seen := time.Date(2024, 5, 1, 10, 30, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		raw  string
+		want []ClientIP
+	}{
+		{"list", `["1.2.3.4", "5.6.7.8"]`, []ClientIP{{IP: "1.2.3.4"}, {IP: "5.6.7.8"}}},
+		{"list text", `"[\"1.2.3.4\"]"`, []ClientIP{{IP: "1.2.3.4"}}},
+		{"dated", `["1.2.3.4 (2024-05-01 10:30:00)"]`, []ClientIP{{IP: "1.2.3.4", SeenAt: seen}}},
+		{"bad date", `["1.2.3.4 (yesterday)"]`, []ClientIP{{IP: "1.2.3.4"}}},
+		{"ipv6", `["2001:db8::1"]`, []ClientIP{{IP: "2001:db8::1"}}},
+		{"lines", `"1.2.3.4\n5.6.7.8"`, []ClientIP{{IP: "1.2.3.4"}, {IP: "5.6.7.8"}}},
+		{"commas", `"1.2.3.4, 5.6.7.8"`, []ClientIP{{IP: "1.2.3.4"}, {IP: "5.6.7.8"}}},
+		{"no record", `"No IP Record"`, nil},
+		{"empty", `[]`, nil},
+		{"not text", `{"ip": "1.2.3.4"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseClientIPs(json.RawMessage(tt.raw))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseClientIPs(%s) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"vpn-bot/internal/marzban"
+)
+
+// Marzban provisions clients as users of a Marzban panel. The client ID is
+// the Marzban username.
+type Marzban struct {
+	client *marzban.Client
+}
+
+func NewMarzban(client *marzban.Client) *Marzban {
+	return &Marzban{client: client}
+}
+
+func (m *Marzban) CreateClient(ctx context.Context, spec ClientSpec) (string, error) {
+	user, err := m.client.CreateUser(ctx, marzban.User{
+		Username:  marzbanUsername(spec.name()),
+		Status:    "active",
+		Expire:    marzbanExpire(spec.Expiry),
+		DataLimit: spec.TotalBytes,
+		// Marzban fills in the protocol settings of the enabled inbounds.
+		Proxies: map[string]map[string]interface{}{"vless": {}},
+	})
+	if err != nil {
+		return "", m.wrap(err)
+	}
+	return user.Username, nil
+}
+
+func (m *Marzban) SetClientExpiry(ctx context.Context, id string, expiry time.Time) error {
+	status, expire := "active", marzbanExpire(expiry)
+	return m.wrap(m.client.ModifyUser(ctx, id, marzban.UserModify{Status: &status, Expire: &expire}))
+}
+
+func (m *Marzban) DisableClient(ctx context.Context, id string) error {
+	status := "disabled"
+	return m.wrap(m.client.ModifyUser(ctx, id, marzban.UserModify{Status: &status}))
+}
+
+func (m *Marzban) DeleteClient(ctx context.Context, id string) error {
+	return m.wrap(m.client.DeleteUser(ctx, id))
+}
+
+func (m *Marzban) GetClient(ctx context.Context, id string) (*Client, error) {
+	user, err := m.client.GetUser(ctx, id)
+	if err != nil {
+		return nil, m.wrap(err)
+	}
+	c := fromMarzban(*user)
+	return &c, nil
+}
+
+func (m *Marzban) ListClients(ctx context.Context) ([]Client, error) {
+	users, err := m.client.ListUsers(ctx)
+	if err != nil {
+		return nil, m.wrap(err)
+	}
+	clients := make([]Client, 0, len(users))
+	for _, u := range users {
+		clients = append(clients, fromMarzban(u))
+	}
+	return clients, nil
+}
+
+// ConnectionLink returns the user's subscription URL, which covers all of
+// their inbounds, or the first single-protocol link without one.
+func (m *Marzban) ConnectionLink(ctx context.Context, id string) (string, error) {
+	user, err := m.client.GetUser(ctx, id)
+	if err != nil {
+		return "", m.wrap(err)
+	}
+	if link := m.client.SubscriptionURL(user); link != "" {
+		return link, nil
+	}
+	if len(user.Links) == 0 {
+		return "", fmt.Errorf("marzban user %s has no links", id)
+	}
+	return user.Links[0], nil
+}
+
+func (m *Marzban) wrap(err error) error {
+	if errors.Is(err, marzban.ErrNotFound) {
+		return ErrNotFound
+	}
+	var statusErr *marzban.StatusError
+	if errors.As(err, &statusErr) {
+		return httpError(err, statusErr.StatusCode)
+	}
+	return httpError(err, 0)
+}
+
+func fromMarzban(u marzban.User) Client {
+	c := Client{
+		ID:         u.Username,
+		Email:      u.Username,
+		Enable:     u.Status == "active",
+		TotalBytes: u.DataLimit,
+		// Marzban counts traffic in one total.
+		Down: u.UsedTraffic,
+	}
+	if u.Expire > 0 {
+		c.Expiry = time.Unix(u.Expire, 0)
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05.999999", u.CreatedAt); err == nil {
+		c.CreatedAt = t
+	}
+	return c
+}
+
+// marzbanUsername turns a client name into a valid Marzban username:
+// 3 to 32 characters of lowercase letters, digits and underscores.
+func marzbanUsername(name string) string {
+	name, _, _ = strings.Cut(strings.ToLower(name), "@")
+	var sb strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	s := sb.String()
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	for len(s) < 3 {
+		s += "_"
+	}
+	return s
+}
+
+// marzbanExpire converts an expiry to Marzban's format, where 0 means never.
+func marzbanExpire(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,23 @@
+package provision
+
+import "testing"
+
+func TestMarzbanUsername(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"user-5@example.com", "user_5"},
+		{"user-5-dev-1700000000@example.com", "user_5_dev_1700000000"},
+		{"User.Name", "user_name"},
+		{"ab", "ab_"},
+		{"", "___"},
+		{"Ключ", "____"},
+		{"a-very-long-client-name-that-goes-on-and-on", "a_very_long_client_name_that_goe"},
+	}
+	for _, tt := range tests {
+		if got := marzbanUsername(tt.name); got != tt.want {
+			t.Errorf("marzbanUsername(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"vpn-bot/internal/outline"
+)
+
+// Outline provisions Shadowsocks access keys on an Outline server. The
+// client ID is the access key ID.
+//
+// Outline keys have no expiry, so it is kept in the key name along with the
+// traffic limit, and an expired or disabled key is blocked with a zero data
+// limit. ExpireClients blocks keys once their expiry passes.
+type Outline struct {
+	client *outline.Client
+}
+
+func NewOutline(client *outline.Client) *Outline {
+	return &Outline{client: client}
+}
+
+// outlineMeta is what the provisioner stores in a key name:
+// "<name>;exp=<unix>;limit=<bytes>".
+type outlineMeta struct {
+	Name   string
+	Expiry time.Time
+	Limit  int64
+}
+
+func (m outlineMeta) String() string {
+	var exp int64
+	if !m.Expiry.IsZero() {
+		exp = m.Expiry.Unix()
+	}
+	return fmt.Sprintf("%s;exp=%d;limit=%d", m.Name, exp, m.Limit)
+}
+
+// parseOutlineMeta reads a key name. Names of keys created outside the bot
+// are kept whole, with no expiry or limit.
+func parseOutlineMeta(s string) outlineMeta {
+	parts := strings.Split(s, ";")
+	meta := outlineMeta{Name: parts[0]}
+	for _, p := range parts[1:] {
+		key, value, _ := strings.Cut(p, "=")
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return outlineMeta{Name: s}
+		}
+		switch key {
+		case "exp":
+			if n > 0 {
+				meta.Expiry = time.Unix(n, 0)
+			}
+		case "limit":
+			meta.Limit = n
+		default:
+			return outlineMeta{Name: s}
+		}
+	}
+	return meta
+}
+
+func (o *Outline) CreateClient(ctx context.Context, spec ClientSpec) (string, error) {
+	key, err := o.client.CreateAccessKey(ctx)
+	if err != nil {
+		return "", o.wrap(err)
+	}
+	meta := outlineMeta{Name: spec.name(), Expiry: spec.Expiry, Limit: spec.TotalBytes}
+	err = o.client.RenameAccessKey(ctx, key.ID, meta.String())
+	if err == nil && meta.Limit > 0 {
+		err = o.client.SetDataLimit(ctx, key.ID, meta.Limit)
+	}
+	if err != nil {
+		if delErr := o.client.DeleteAccessKey(ctx, key.ID); delErr != nil {
+			log.Printf("delete outline key %s: %v", key.ID, delErr)
+		}
+		return "", o.wrap(err)
+	}
+	return key.ID, nil
+}
+
+// SetClientExpiry stores the expiry and unblocks the key, or blocks it if
+// the expiry is already past.
+func (o *Outline) SetClientExpiry(ctx context.Context, id string, expiry time.Time) error {
+	key, err := o.client.GetAccessKey(ctx, id)
+	if err != nil {
+		return o.wrap(err)
+	}
+	meta := parseOutlineMeta(key.Name)
+	meta.Expiry = expiry
+	if err := o.client.RenameAccessKey(ctx, id, meta.String()); err != nil {
+		return o.wrap(err)
+	}
+	switch {
+	case !expiry.IsZero() && !expiry.After(time.Now()):
+		err = o.client.SetDataLimit(ctx, id, 0)
+	case meta.Limit > 0:
+		err = o.client.SetDataLimit(ctx, id, meta.Limit)
+	default:
+		err = o.client.RemoveDataLimit(ctx, id)
+	}
+	return o.wrap(err)
+}
+
+func (o *Outline) DisableClient(ctx context.Context, id string) error {
+	return o.wrap(o.client.SetDataLimit(ctx, id, 0))
+}
+
+func (o *Outline) DeleteClient(ctx context.Context, id string) error {
+	return o.wrap(o.client.DeleteAccessKey(ctx, id))
+}
+
+func (o *Outline) GetClient(ctx context.Context, id string) (*Client, error) {
+	key, err := o.client.GetAccessKey(ctx, id)
+	if err != nil {
+		return nil, o.wrap(err)
+	}
+	transfer, err := o.client.TransferMetrics(ctx)
+	if err != nil {
+		return nil, o.wrap(err)
+	}
+	c := fromOutline(*key, transfer)
+	return &c, nil
+}
+
+func (o *Outline) ListClients(ctx context.Context) ([]Client, error) {
+	keys, err := o.client.ListAccessKeys(ctx)
+	if err != nil {
+		return nil, o.wrap(err)
+	}
+	transfer, err := o.client.TransferMetrics(ctx)
+	if err != nil {
+		return nil, o.wrap(err)
+	}
+	clients := make([]Client, 0, len(keys))
+	for _, k := range keys {
+		clients = append(clients, fromOutline(k, transfer))
+	}
+	return clients, nil
+}
+
+// ConnectionLink returns the key's ss:// access URL.
+func (o *Outline) ConnectionLink(ctx context.Context, id string) (string, error) {
+	key, err := o.client.GetAccessKey(ctx, id)
+	if err != nil {
+		return "", o.wrap(err)
+	}
+	return key.AccessURL, nil
+}
+
+// ExpireClients blocks the enabled keys whose expiry has passed and returns
+// how many it blocked.
+func (o *Outline) ExpireClients(ctx context.Context) (int, error) {
+	keys, err := o.client.ListAccessKeys(ctx)
+	if err != nil {
+		return 0, o.wrap(err)
+	}
+	now := time.Now()
+	expired := 0
+	for _, k := range keys {
+		meta := parseOutlineMeta(k.Name)
+		if meta.Expiry.IsZero() || meta.Expiry.After(now) || blocked(k) {
+			continue
+		}
+		if err := o.client.SetDataLimit(ctx, k.ID, 0); err != nil {
+			return expired, fmt.Errorf("block key %s: %w", k.ID, o.wrap(err))
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+func (o *Outline) wrap(err error) error {
+	if errors.Is(err, outline.ErrNotFound) {
+		return ErrNotFound
+	}
+	var statusErr *outline.StatusError
+	if errors.As(err, &statusErr) {
+		return httpError(err, statusErr.StatusCode)
+	}
+	return httpError(err, 0)
+}
+
+func blocked(k outline.AccessKey) bool {
+	return k.DataLimit != nil && k.DataLimit.Bytes == 0
+}
+
+func fromOutline(k outline.AccessKey, transfer map[string]int64) Client {
+	meta := parseOutlineMeta(k.Name)
+	return Client{
+		ID:         k.ID,
+		Email:      meta.Name,
+		Enable:     !blocked(k),
+		Expiry:     meta.Expiry,
+		TotalBytes: meta.Limit,
+		// Outline reports the traffic of the last 30 days in one total.
+		Down: transfer[k.ID],
+	}
+}
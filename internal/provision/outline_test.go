@@ -0,0 +1,42 @@
+package provision
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOutlineMeta(t *testing.T) {
+	exp := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		want outlineMeta
+	}{
+		{"user-5@example.com;exp=1700000000;limit=1073741824", outlineMeta{Name: "user-5@example.com", Expiry: exp, Limit: 1 << 30}},
+		{"user-5@example.com;exp=0;limit=0", outlineMeta{Name: "user-5@example.com"}},
+		{"user-5@example.com;limit=5", outlineMeta{Name: "user-5@example.com", Limit: 5}},
+		{"Office laptop", outlineMeta{Name: "Office laptop"}},
+		{"", outlineMeta{}},
+		// Names that merely contain the separator are kept whole.
+		{"a;b", outlineMeta{Name: "a;b"}},
+		{"a;exp=soon", outlineMeta{Name: "a;exp=soon"}},
+		{"a;owner=5", outlineMeta{Name: "a;owner=5"}},
+	}
+	for _, tt := range tests {
+		got := parseOutlineMeta(tt.name)
+		if got.Name != tt.want.Name || !got.Expiry.Equal(tt.want.Expiry) || got.Limit != tt.want.Limit {
+			t.Errorf("parseOutlineMeta(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutlineMetaRoundTrip(t *testing.T) {
+	for _, m := range []outlineMeta{
+		{Name: "user-5@example.com", Expiry: time.Unix(1700000000, 0), Limit: 100},
+		{Name: "user-6@example.com"},
+	} {
+		got := parseOutlineMeta(m.String())
+		if got.Name != m.Name || !got.Expiry.Equal(m.Expiry) || got.Limit != m.Limit {
+			t.Errorf("parseOutlineMeta(%q) = %+v, want %+v", m.String(), got, m)
+		}
+	}
+}
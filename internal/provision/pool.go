@@ -0,0 +1,310 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Pool routes clients to named servers. IDs of the primary server are kept
+// as the server returns them, so keys issued before other servers existed
+// stay valid; IDs of other servers are prefixed with "<name>:".
+//
+// Each server has its own retries and circuit breaker, so one server being
+// down does not hold up calls to the others.
+type Pool struct {
+	servers map[string]Provisioner
+	guards  map[string]*guard
+	primary string
+	def     string
+}
+
+// NewPool creates a pool. New clients go to def unless their spec names a
+// server.
+func NewPool(primary, def string, servers map[string]Provisioner) (*Pool, error) {
+	if _, ok := servers[primary]; !ok {
+		return nil, fmt.Errorf("primary server %q is not configured", primary)
+	}
+	if _, ok := servers[def]; !ok {
+		return nil, fmt.Errorf("default server %q is not configured", def)
+	}
+	guards := make(map[string]*guard, len(servers))
+	for name := range servers {
+		guards[name] = newGuard()
+	}
+	return &Pool{servers: servers, guards: guards, primary: primary, def: def}, nil
+}
+
+// SetRetryPolicy replaces DefaultRetryPolicy on every server. It must be
+// called before the pool is used.
+func (p *Pool) SetRetryPolicy(policy RetryPolicy) {
+	for _, g := range p.guards {
+		g.retry = policy
+	}
+}
+
+// SetBreakerPolicy replaces DefaultBreakerPolicy on every server. It must be
+// called before the pool is used.
+func (p *Pool) SetBreakerPolicy(policy BreakerPolicy) {
+	for _, g := range p.guards {
+		g.breaker = policy
+	}
+}
+
+// OnAvailabilityChange sets fn to be called when a server's circuit breaker
+// opens, with the last error, and when the server recovers.
+func (p *Pool) OnAvailabilityChange(fn func(server string, available bool, err error)) {
+	for name, g := range p.guards {
+		name := name
+		g.mu.Lock()
+		g.onChange = func(available bool, err error) { fn(name, available, err) }
+		g.mu.Unlock()
+	}
+}
+
+// call runs fn against the named server through its guard. Calls that are
+// safe to repeat are retried while the server is unavailable.
+func (p *Pool) call(ctx context.Context, name string, idempotent bool, fn func() error) error {
+	return p.guards[name].call(ctx, idempotent, fn)
+}
+
+// route splits a pool ID into its server's name and the server's own ID.
+func (p *Pool) route(id string) (string, string) {
+	if name, local, ok := strings.Cut(id, ":"); ok {
+		if _, ok := p.servers[name]; ok {
+			return name, local
+		}
+	}
+	return p.primary, id
+}
+
+// ServerOf returns the name of the server holding the client, so that
+// clients of the same user can be created next to it.
+func (p *Pool) ServerOf(id string) string {
+	name, _ := p.route(id)
+	return name
+}
+
+// ExpiryPrecision returns the precision of the client's server, zero if it
+// keeps expiries to the second.
+func (p *Pool) ExpiryPrecision(id string) time.Duration {
+	name, local := p.route(id)
+	if s, ok := p.servers[name].(Precision); ok {
+		return s.ExpiryPrecision(local)
+	}
+	return 0
+}
+
+func (p *Pool) ClientLimitIP(ctx context.Context, id string) (int, error) {
+	name, local := p.route(id)
+	l, ok := p.servers[name].(IPLimiter)
+	if !ok {
+		return 0, nil
+	}
+	var limit int
+	err := p.call(ctx, name, true, func() (err error) {
+		limit, err = l.ClientLimitIP(ctx, local)
+		return err
+	})
+	return limit, err
+}
+
+func (p *Pool) poolID(server, id string) string {
+	if server == p.primary {
+		return id
+	}
+	return server + ":" + id
+}
+
+func (p *Pool) CreateClient(ctx context.Context, spec ClientSpec) (string, error) {
+	name := spec.Server
+	if name == "" {
+		name = p.def
+	}
+	s, ok := p.servers[name]
+	if !ok {
+		return "", fmt.Errorf("unknown server %q", name)
+	}
+	var id string
+	err := p.call(ctx, name, false, func() (err error) {
+		id, err = s.CreateClient(ctx, spec)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return p.poolID(name, id), nil
+}
+
+func (p *Pool) SetClientExpiry(ctx context.Context, id string, expiry time.Time) error {
+	name, local := p.route(id)
+	return p.call(ctx, name, true, func() error {
+		return p.servers[name].SetClientExpiry(ctx, local, expiry)
+	})
+}
+
+func (p *Pool) DisableClient(ctx context.Context, id string) error {
+	name, local := p.route(id)
+	return p.call(ctx, name, true, func() error {
+		return p.servers[name].DisableClient(ctx, local)
+	})
+}
+
+func (p *Pool) DeleteClient(ctx context.Context, id string) error {
+	name, local := p.route(id)
+	return p.call(ctx, name, true, func() error {
+		return p.servers[name].DeleteClient(ctx, local)
+	})
+}
+
+func (p *Pool) GetClient(ctx context.Context, id string) (*Client, error) {
+	name, local := p.route(id)
+	var c *Client
+	err := p.call(ctx, name, true, func() (err error) {
+		c, err = p.servers[name].GetClient(ctx, local)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.ID = id
+	return c, nil
+}
+
+// ServerErrors is returned by pool calls spanning every server when some
+// of them failed, keyed by server name. The results of the other servers
+// are returned along with it.
+type ServerErrors map[string]error
+
+func (e ServerErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %v", name, e[name])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ListClients returns the clients of all servers with pool IDs. If some
+// servers cannot be listed, the clients of the others are returned with
+// ServerErrors.
+func (p *Pool) ListClients(ctx context.Context) ([]Client, error) {
+	var all []Client
+	failed := ServerErrors{}
+	for name, s := range p.servers {
+		var clients []Client
+		err := p.call(ctx, name, true, func() (err error) {
+			clients, err = s.ListClients(ctx)
+			return err
+		})
+		if err != nil {
+			failed[name] = err
+			continue
+		}
+		for _, c := range clients {
+			c.ID = p.poolID(name, c.ID)
+			all = append(all, c)
+		}
+	}
+	if len(failed) > 0 {
+		return all, failed
+	}
+	return all, nil
+}
+
+func (p *Pool) ConnectionLink(ctx context.Context, id string) (string, error) {
+	name, local := p.route(id)
+	var link string
+	err := p.call(ctx, name, true, func() (err error) {
+		link, err = p.servers[name].ConnectionLink(ctx, local)
+		return err
+	})
+	return link, err
+}
+
+func (p *Pool) ClientConfig(ctx context.Context, id string) (string, []byte, error) {
+	name, local := p.route(id)
+	c, ok := p.servers[name].(Configurer)
+	if !ok {
+		return "", nil, ErrNotSupported
+	}
+	var file string
+	var data []byte
+	err := p.call(ctx, name, true, func() (err error) {
+		file, data, err = c.ClientConfig(ctx, local)
+		return err
+	})
+	return file, data, err
+}
+
+// ExpireClients expires clients on the servers that need it. A server
+// failing does not stop the others; the failures are returned as
+// ServerErrors.
+func (p *Pool) ExpireClients(ctx context.Context) (int, error) {
+	total := 0
+	failed := ServerErrors{}
+	for name, s := range p.servers {
+		e, ok := s.(Expirer)
+		if !ok {
+			continue
+		}
+		var n int
+		err := p.call(ctx, name, true, func() (err error) {
+			n, err = e.ExpireClients(ctx)
+			return err
+		})
+		// Count once the guard is done, so retries are not added up.
+		total += n
+		if err != nil {
+			failed[name] = err
+		}
+	}
+	if len(failed) > 0 {
+		return total, failed
+	}
+	return total, nil
+}
+
+// OnlineClients returns the online clients of the servers that report
+// them, with pool IDs.
+func (p *Pool) OnlineClients(ctx context.Context) ([]string, error) {
+	var all []string
+	for name, s := range p.servers {
+		m, ok := s.(Monitor)
+		if !ok {
+			continue
+		}
+		var ids []string
+		err := p.call(ctx, name, true, func() (err error) {
+			ids, err = m.OnlineClients(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, id := range ids {
+			all = append(all, p.poolID(name, id))
+		}
+	}
+	return all, nil
+}
+
+func (p *Pool) ClientIPs(ctx context.Context, id string) ([]ClientIP, error) {
+	name, local := p.route(id)
+	m, ok := p.servers[name].(Monitor)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	var ips []ClientIP
+	err := p.call(ctx, name, true, func() (err error) {
+		ips, err = m.ClientIPs(ctx, local)
+		return err
+	})
+	return ips, err
+}
@@ -0,0 +1,106 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeServer lists clients and expires them, failing with err if set.
+type fakeServer struct {
+	clients []Client
+	expired int
+	err     error
+}
+
+func (f *fakeServer) CreateClient(context.Context, ClientSpec) (string, error) { return "new", f.err }
+func (f *fakeServer) SetClientExpiry(context.Context, string, time.Time) error { return f.err }
+func (f *fakeServer) DisableClient(context.Context, string) error              { return f.err }
+func (f *fakeServer) DeleteClient(context.Context, string) error               { return f.err }
+func (f *fakeServer) ConnectionLink(context.Context, string) (string, error)   { return "", f.err }
+
+func (f *fakeServer) GetClient(_ context.Context, id string) (*Client, error) {
+	return &Client{ID: id}, f.err
+}
+
+func (f *fakeServer) ListClients(context.Context) ([]Client, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.clients, nil
+}
+
+func (f *fakeServer) ExpireClients(context.Context) (int, error) {
+	return f.expired, f.err
+}
+
+func testPool(t *testing.T, servers map[string]Provisioner) *Pool {
+	t.Helper()
+	p, err := NewPool("main", "main", servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetRetryPolicy(RetryPolicy{Attempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	return p
+}
+
+func TestPoolRoute(t *testing.T) {
+	p := testPool(t, map[string]Provisioner{"main": &fakeServer{}, "de": &fakeServer{}})
+	tests := []struct {
+		id, server, local string
+	}{
+		{"abc", "main", "abc"},
+		{"de:abc", "de", "abc"},
+		// An unknown prefix is part of a primary server ID.
+		{"nl:abc", "main", "nl:abc"},
+	}
+	for _, tt := range tests {
+		server, local := p.route(tt.id)
+		if server != tt.server || local != tt.local {
+			t.Errorf("route(%q) = %q, %q; want %q, %q", tt.id, server, local, tt.server, tt.local)
+		}
+		if id := p.poolID(server, local); id != tt.id {
+			t.Errorf("poolID(%q, %q) = %q, want %q", server, local, id, tt.id)
+		}
+	}
+}
+
+func TestPoolListClientsPartial(t *testing.T) {
+	p := testPool(t, map[string]Provisioner{
+		"main": &fakeServer{clients: []Client{{ID: "a"}}},
+		"de":   &fakeServer{clients: []Client{{ID: "b"}}},
+		"nl":   &fakeServer{err: ErrUnavailable},
+	})
+	clients, err := p.ListClients(context.Background())
+	var failed ServerErrors
+	if !errors.As(err, &failed) || len(failed) != 1 || !errors.Is(failed["nl"], ErrUnavailable) {
+		t.Fatalf("ListClients error = %v, want nl unavailable", err)
+	}
+	var ids []string
+	for _, c := range clients {
+		ids = append(ids, c.ID)
+	}
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "de:b" {
+		t.Errorf("ListClients = %v, want [a de:b]", ids)
+	}
+}
+
+func TestPoolExpireClients(t *testing.T) {
+	p := testPool(t, map[string]Provisioner{
+		"main": &fakeServer{expired: 2},
+		"de":   &fakeServer{expired: 3, err: ErrUnavailable},
+		"nl":   &fakeServer{expired: 4},
+	})
+	n, err := p.ExpireClients(context.Background())
+	var failed ServerErrors
+	if !errors.As(err, &failed) || len(failed) != 1 || failed["de"] == nil {
+		t.Fatalf("ExpireClients error = %v, want de failed", err)
+	}
+	// The failing server counts its last attempt only.
+	if n != 9 {
+		t.Errorf("ExpireClients = %d, want 9", n)
+	}
+}
@@ -0,0 +1,156 @@
+// Package provision abstracts the VPN panels that hold client keys.
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"vpn-bot/internal/panel"
+)
+
+// Errors of all backends, shared with package panel so 3x-ui errors match
+// them as they are.
+var (
+	ErrNotFound     = panel.ErrNotFound
+	ErrDuplicate    = panel.ErrDuplicate
+	ErrUnauthorized = panel.ErrUnauthorized
+	ErrUnavailable  = panel.ErrUnavailable
+)
+
+// ClientSpec describes a client to create. Zero values mean backend
+// defaults.
+type ClientSpec struct {
+	UserID int
+	// Email identifies the client in the panel and must be unique.
+	Email   string
+	LimitIP int
+	// TotalBytes is the traffic limit; zero means unlimited.
+	TotalBytes int64
+	Expiry     time.Time
+	// NoExpiry creates a client that never expires, for backends that
+	// default a zero Expiry to a limited term.
+	NoExpiry bool
+	// Server selects the server in a Pool; empty means the default one.
+	Server string
+}
+
+// name returns the client's panel name: Email, or one derived from UserID.
+func (s ClientSpec) name() string {
+	if s.Email != "" {
+		return s.Email
+	}
+	return fmt.Sprintf("user-%d@example.com", s.UserID)
+}
+
+// Client is the state and usage of a panel client.
+type Client struct {
+	ID     string
+	Email  string
+	Enable bool
+	// Expiry is zero for clients that never expire.
+	Expiry     time.Time
+	TotalBytes int64
+	Up         int64
+	Down       int64
+	// CreatedAt is zero when the panel does not report it.
+	CreatedAt time.Time
+}
+
+// Provisioner manages clients on one VPN panel.
+type Provisioner interface {
+	// CreateClient adds a client and returns its ID.
+	CreateClient(ctx context.Context, spec ClientSpec) (string, error)
+	// SetClientExpiry sets the expiry and enables the client if it was
+	// disabled.
+	SetClientExpiry(ctx context.Context, id string, expiry time.Time) error
+	DisableClient(ctx context.Context, id string) error
+	DeleteClient(ctx context.Context, id string) error
+	// GetClient returns the client with its traffic usage.
+	GetClient(ctx context.Context, id string) (*Client, error)
+	ListClients(ctx context.Context) ([]Client, error)
+	// ConnectionLink returns what a client app imports to connect.
+	ConnectionLink(ctx context.Context, id string) (string, error)
+}
+
+// httpError makes an error of an HTTP backend match the shared errors:
+// failures to reach the server match ErrUnavailable, and error statuses
+// the error they stand for. status is zero if there was no answer.
+func httpError(err error, status int) error {
+	var urlErr *url.Error
+	var sentinel error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &urlErr):
+		sentinel = ErrUnavailable
+	case status == http.StatusNotFound:
+		sentinel = ErrNotFound
+	case status == http.StatusConflict:
+		sentinel = ErrDuplicate
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		sentinel = ErrUnauthorized
+	case status >= 500:
+		sentinel = ErrUnavailable
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %v", sentinel, err)
+}
+
+// ErrNotSupported is returned for operations a backend does not provide.
+var ErrNotSupported = errors.New("not supported by the panel")
+
+// ClientIP is an address a client connected from. SeenAt is zero when the
+// panel does not record when.
+type ClientIP struct {
+	IP     string
+	SeenAt time.Time
+}
+
+// Monitor is implemented by backends that report connections.
+type Monitor interface {
+	// OnlineClients returns the IDs of the clients connected now.
+	OnlineClients(ctx context.Context) ([]string, error)
+	// ClientIPs returns the addresses the client recently connected from.
+	ClientIPs(ctx context.Context, id string) ([]ClientIP, error)
+}
+
+// Precision is implemented by backends that keep expiries less precisely
+// than to the second.
+type Precision interface {
+	// ExpiryPrecision returns the unit the client's expiry is truncated to.
+	ExpiryPrecision(id string) time.Duration
+}
+
+// Configurer is implemented by backends whose clients import a
+// configuration file instead of a link. Their ConnectionLink returns
+// ErrNotSupported.
+type Configurer interface {
+	// ClientConfig returns the client's configuration file and its name.
+	ClientConfig(ctx context.Context, id string) (name string, data []byte, err error)
+}
+
+// IPLimiter is implemented by backends that limit how many addresses a
+// client may connect from at once.
+type IPLimiter interface {
+	// ClientLimitIP returns the client's limit, zero if it has none.
+	ClientLimitIP(ctx context.Context, id string) (int, error)
+}
+
+// Locator is implemented by provisioners spanning several servers.
+type Locator interface {
+	// ServerOf returns the name of the server holding the client.
+	ServerOf(id string) string
+}
+
+// Expirer is implemented by backends whose panel does not expire clients
+// itself.
+type Expirer interface {
+	// ExpireClients disables the clients past their expiry and returns how
+	// many it disabled.
+	ExpireClients(ctx context.Context) (int, error)
+}
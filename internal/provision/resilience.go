@@ -0,0 +1,161 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy sets how idempotent calls are retried after a server was
+// unavailable. Delays grow exponentially from BaseDelay up to MaxDelay,
+// with jitter.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used by NewPool.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// delay returns the wait before retry n, counting from 1: a random duration
+// between half and all of the exponential delay.
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay << (n - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// BreakerPolicy sets when a server's circuit breaker opens: after Failures
+// failed calls in a row, calls fail fast for Cooldown, after which a single
+// call probes the server.
+type BreakerPolicy struct {
+	Failures int
+	Cooldown time.Duration
+}
+
+// DefaultBreakerPolicy is used by NewPool.
+var DefaultBreakerPolicy = BreakerPolicy{Failures: 5, Cooldown: 30 * time.Second}
+
+// guard retries calls to one server and keeps its circuit breaker. A call
+// failed when it returns ErrUnavailable; any other result means the server
+// answered.
+type guard struct {
+	retry RetryPolicy
+
+	mu        sync.Mutex
+	breaker   BreakerPolicy
+	failures  int
+	open      bool
+	openUntil time.Time
+	probing   bool
+	onChange  func(available bool, err error)
+}
+
+func newGuard() *guard {
+	return &guard{retry: DefaultRetryPolicy, breaker: DefaultBreakerPolicy}
+}
+
+// call runs fn through the breaker, retrying it while the server is
+// unavailable if it is safe to repeat.
+func (g *guard) call(ctx context.Context, idempotent bool, fn func() error) error {
+	probe, err := g.allow()
+	if err != nil {
+		return err
+	}
+	if probe {
+		defer g.release()
+	}
+	attempts := 1
+	if idempotent && g.retry.Attempts > 1 {
+		attempts = g.retry.Attempts
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !errors.Is(err, ErrUnavailable) {
+			g.success()
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= attempts {
+			g.failure(err)
+			return err
+		}
+		if err := sleep(ctx, g.retry.delay(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// allow reports whether a call may be made and whether it is the probe of
+// an open breaker, which the caller must release when done.
+func (g *guard) allow() (probe bool, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.open {
+		return false, nil
+	}
+	if g.probing || time.Now().Before(g.openUntil) {
+		return false, ErrUnavailable
+	}
+	g.probing = true
+	return true, nil
+}
+
+// release ends a probe that recorded neither a success nor a failure, such
+// as one cancelled by its context, so that the next call probes again.
+func (g *guard) release() {
+	g.mu.Lock()
+	g.probing = false
+	g.mu.Unlock()
+}
+
+func (g *guard) success() {
+	g.mu.Lock()
+	wasOpen := g.open
+	g.failures, g.open, g.probing = 0, false, false
+	onChange := g.onChange
+	g.mu.Unlock()
+	if wasOpen && onChange != nil {
+		onChange(true, nil)
+	}
+}
+
+func (g *guard) failure(err error) {
+	g.mu.Lock()
+	g.failures++
+	opened := false
+	switch {
+	case g.open:
+		// The probe failed; wait another cooldown.
+		g.probing = false
+		g.openUntil = time.Now().Add(g.breaker.Cooldown)
+	case g.breaker.Failures > 0 && g.failures >= g.breaker.Failures:
+		g.open, opened = true, true
+		g.openUntil = time.Now().Add(g.breaker.Cooldown)
+	}
+	onChange := g.onChange
+	g.mu.Unlock()
+	if opened && onChange != nil {
+		onChange(false, err)
+	}
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
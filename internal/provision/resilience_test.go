@@ -0,0 +1,113 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testGuard() *guard {
+	g := newGuard()
+	g.retry = RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	g.breaker = BreakerPolicy{Failures: 2, Cooldown: 20 * time.Millisecond}
+	return g
+}
+
+func TestGuardRetries(t *testing.T) {
+	errOther := errors.New("bad request")
+	tests := []struct {
+		name       string
+		idempotent bool
+		results    []error
+		want       error
+		calls      int
+	}{
+		{"success", true, []error{nil}, nil, 1},
+		{"answered with an error", true, []error{errOther}, errOther, 1},
+		{"recovers", true, []error{ErrUnavailable, ErrUnavailable, nil}, nil, 3},
+		{"gives up", true, []error{ErrUnavailable, ErrUnavailable, ErrUnavailable}, ErrUnavailable, 3},
+		{"not repeated", false, []error{ErrUnavailable, nil}, ErrUnavailable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := testGuard()
+			calls := 0
+			err := g.call(context.Background(), tt.idempotent, func() error {
+				calls++
+				return tt.results[calls-1]
+			})
+			if !errors.Is(err, tt.want) || calls != tt.calls {
+				t.Errorf("call = %v after %d calls, want %v after %d", err, calls, tt.want, tt.calls)
+			}
+		})
+	}
+}
+
+func TestGuardBreaker(t *testing.T) {
+	g := testGuard()
+	var changes []bool
+	g.onChange = func(available bool, err error) { changes = append(changes, available) }
+	ctx := context.Background()
+	fail := func() error { return ErrUnavailable }
+	calls := 0
+	succeed := func() error { calls++; return nil }
+
+	// Two failed calls in a row open the breaker.
+	for i := 0; i < 2; i++ {
+		if err := g.call(ctx, false, fail); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("call %d = %v, want ErrUnavailable", i, err)
+		}
+	}
+	if len(changes) != 1 || changes[0] {
+		t.Fatalf("changes = %v, want [false]", changes)
+	}
+
+	// While open, calls fail without reaching the server.
+	if err := g.call(ctx, true, succeed); !errors.Is(err, ErrUnavailable) || calls != 0 {
+		t.Fatalf("call while open = %v after %d calls, want ErrUnavailable after 0", err, calls)
+	}
+
+	// After the cooldown a failed probe keeps the breaker open.
+	time.Sleep(25 * time.Millisecond)
+	if err := g.call(ctx, false, fail); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("failed probe = %v, want ErrUnavailable", err)
+	}
+	if err := g.call(ctx, true, succeed); !errors.Is(err, ErrUnavailable) || calls != 0 {
+		t.Fatalf("call after a failed probe = %v after %d calls, want ErrUnavailable after 0", err, calls)
+	}
+
+	// A successful probe closes it.
+	time.Sleep(25 * time.Millisecond)
+	if err := g.call(ctx, true, succeed); err != nil || calls != 1 {
+		t.Fatalf("probe = %v after %d calls, want nil after 1", err, calls)
+	}
+	if len(changes) != 2 || !changes[1] {
+		t.Errorf("changes = %v, want [false true]", changes)
+	}
+	if err := g.call(ctx, true, succeed); err != nil || calls != 2 {
+		t.Errorf("call after recovery = %v after %d calls, want nil after 2", err, calls)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		n   int
+		max time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{64, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := p.delay(tt.n); d < tt.max/2 || d > tt.max {
+				t.Errorf("delay(%d) = %v, want between %v and %v", tt.n, d, tt.max/2, tt.max)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WGEasy provisions WireGuard peers on a wg-easy server. The client ID is
+// the peer ID assigned by wg-easy.
+type WGEasy struct {
+	baseURL    string
+	password   string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	loggedIn bool
+}
+
+type wgClient struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Enabled    bool       `json:"enabled"`
+	ExpiredAt  *time.Time `json:"expiredAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	TransferRx int64      `json:"transferRx"`
+	TransferTx int64      `json:"transferTx"`
+}
+
+// wgDateLayout is the format of wg-easy expiry dates, which have a day
+// precision.
+const wgDateLayout = "2006-01-02"
+
+func NewWGEasy(baseURL, password string) *WGEasy {
+	jar, _ := cookiejar.New(nil)
+	return &WGEasy{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/",
+		password:   password,
+		httpClient: &http.Client{Timeout: 15 * time.Second, Jar: jar},
+	}
+}
+
+// CreateClient adds a peer. wg-easy does not return the new peer and
+// allows duplicate names, so it is the newest peer with the name that was
+// not there before.
+func (w *WGEasy) CreateClient(ctx context.Context, spec ClientSpec) (string, error) {
+	before, err := w.list(ctx)
+	if err != nil {
+		return "", err
+	}
+	existing := make(map[string]bool, len(before))
+	for _, c := range before {
+		existing[c.ID] = true
+	}
+
+	name := spec.name()
+	body := map[string]string{"name": name}
+	if !spec.Expiry.IsZero() {
+		body["expiredDate"] = spec.Expiry.UTC().Format(wgDateLayout)
+	}
+	if err := w.do(ctx, http.MethodPost, "api/wireguard/client", body, nil); err != nil {
+		return "", err
+	}
+	clients, err := w.list(ctx)
+	if err != nil {
+		return "", err
+	}
+	var created *wgClient
+	for i, c := range clients {
+		if c.Name != name || existing[c.ID] {
+			continue
+		}
+		if created == nil || c.CreatedAt.After(created.CreatedAt) {
+			created = &clients[i]
+		}
+	}
+	if created == nil {
+		return "", fmt.Errorf("wg-easy peer %q not found after creation", name)
+	}
+	return created.ID, nil
+}
+
+func (w *WGEasy) SetClientExpiry(ctx context.Context, id string, expiry time.Time) error {
+	body := map[string]interface{}{"expireDate": nil}
+	if !expiry.IsZero() {
+		body["expireDate"] = expiry.UTC().Format(wgDateLayout)
+	}
+	if err := w.do(ctx, http.MethodPut, "api/wireguard/client/"+url.PathEscape(id)+"/expireDate", body, nil); err != nil {
+		return err
+	}
+	return w.do(ctx, http.MethodPost, "api/wireguard/client/"+url.PathEscape(id)+"/enable", nil, nil)
+}
+
+// ExpiryPrecision is a day: wg-easy keeps expiry dates.
+func (w *WGEasy) ExpiryPrecision(string) time.Duration {
+	return 24 * time.Hour
+}
+
+func (w *WGEasy) DisableClient(ctx context.Context, id string) error {
+	return w.do(ctx, http.MethodPost, "api/wireguard/client/"+url.PathEscape(id)+"/disable", nil, nil)
+}
+
+func (w *WGEasy) DeleteClient(ctx context.Context, id string) error {
+	return w.do(ctx, http.MethodDelete, "api/wireguard/client/"+url.PathEscape(id), nil, nil)
+}
+
+// GetClient finds the peer in the list, since wg-easy has no endpoint for a
+// single peer.
+func (w *WGEasy) GetClient(ctx context.Context, id string) (*Client, error) {
+	clients, err := w.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range clients {
+		if c.ID == id {
+			client := c.client()
+			return &client, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (w *WGEasy) ListClients(ctx context.Context) ([]Client, error) {
+	clients, err := w.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		result = append(result, c.client())
+	}
+	return result, nil
+}
+
+// ConnectionLink is not supported: WireGuard peers import the file from
+// ClientConfig, which holds the peer's private key.
+func (w *WGEasy) ConnectionLink(context.Context, string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// ClientConfig returns the peer's WireGuard configuration file. Apps name
+// the tunnel after the file, and Linux limits interface names to 15
+// characters, so the name keeps only the start of the peer ID.
+func (w *WGEasy) ClientConfig(ctx context.Context, id string) (string, []byte, error) {
+	var conf bytes.Buffer
+	if err := w.do(ctx, http.MethodGet, "api/wireguard/client/"+url.PathEscape(id)+"/configuration", nil, &conf); err != nil {
+		return "", nil, err
+	}
+	short := id
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	return "wg-" + short + ".conf", conf.Bytes(), nil
+}
+
+func (w *WGEasy) list(ctx context.Context) ([]wgClient, error) {
+	var clients []wgClient
+	err := w.do(ctx, http.MethodGet, "api/wireguard/client", nil, &clients)
+	return clients, err
+}
+
+func (c wgClient) client() Client {
+	client := Client{ID: c.ID, Email: c.Name, Enable: c.Enabled, Up: c.TransferRx, Down: c.TransferTx, CreatedAt: c.CreatedAt}
+	if c.ExpiredAt != nil {
+		client.Expiry = *c.ExpiredAt
+	}
+	return client
+}
+
+func (w *WGEasy) login(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.loggedIn {
+		return nil
+	}
+	data, err := json.Marshal(map[string]string{"password": w.password})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.baseURL+"api/session", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return httpError(err, 0)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return httpError(fmt.Errorf("wg-easy login failed: %s", resp.Status), resp.StatusCode)
+	}
+	w.loggedIn = true
+	return nil
+}
+
+// do sends a request with the session cookie, logging in again once if
+// wg-easy has dropped the session, as it does on restart. dest is decoded
+// as JSON, or filled with the raw body if it is a *bytes.Buffer.
+func (w *WGEasy) do(ctx context.Context, method, path string, body, dest interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if err := w.login(ctx); err != nil {
+			return err
+		}
+		var reader io.Reader
+		if data != nil {
+			reader = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, w.baseURL+path, reader)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := w.httpClient.Do(req)
+		if err != nil {
+			return httpError(err, 0)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			w.mu.Lock()
+			w.loggedIn = false
+			w.mu.Unlock()
+			continue
+		}
+		return decodeWG(resp, dest)
+	}
+}
+
+// decodeWG reads a wg-easy response into dest as do describes.
+func decodeWG(resp *http.Response, dest interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return httpError(fmt.Errorf("wg-easy request failed: %s", resp.Status), resp.StatusCode)
+	}
+	switch d := dest.(type) {
+	case nil:
+		return nil
+	case *bytes.Buffer:
+		_, err := d.ReadFrom(resp.Body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(dest)
+	}
+}
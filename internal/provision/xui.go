@@ -0,0 +1,133 @@
+package provision
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"vpn-bot/internal/panel"
+)
+
+// XUI provisions clients on a 3x-ui panel.
+type XUI struct {
+	client          *panel.Client
+	subscriptionURL string
+}
+
+// NewXUI creates the provisioner. subscriptionURL is the client
+// subscription address, with "{key}" replaced by the client ID or the ID
+// appended; empty means the ID itself is imported.
+func NewXUI(client *panel.Client, subscriptionURL string) *XUI {
+	return &XUI{client: client, subscriptionURL: subscriptionURL}
+}
+
+func (x *XUI) CreateClient(ctx context.Context, spec ClientSpec) (string, error) {
+	return x.client.CreateClient(ctx, spec.UserID, panel.ClientOptions{
+		Email:      spec.Email,
+		LimitIP:    spec.LimitIP,
+		TotalBytes: spec.TotalBytes,
+		Expiry:     spec.Expiry,
+		NoExpiry:   spec.NoExpiry,
+	})
+}
+
+func (x *XUI) SetClientExpiry(ctx context.Context, id string, expiry time.Time) error {
+	return x.client.SetClientExpiry(ctx, id, expiry)
+}
+
+func (x *XUI) DisableClient(ctx context.Context, id string) error {
+	return x.client.DisableClient(ctx, id)
+}
+
+func (x *XUI) DeleteClient(ctx context.Context, id string) error {
+	return x.client.DelClient(ctx, id)
+}
+
+func (x *XUI) GetClient(ctx context.Context, id string) (*Client, error) {
+	info, err := x.client.GetClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c := fromXUI(*info)
+	return &c, nil
+}
+
+func (x *XUI) ListClients(ctx context.Context) ([]Client, error) {
+	infos, err := x.client.ListClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]Client, 0, len(infos))
+	for _, info := range infos {
+		clients = append(clients, fromXUI(info))
+	}
+	return clients, nil
+}
+
+// ConnectionLink returns the client's subscription link, or the client ID
+// without a subscription address.
+func (x *XUI) ConnectionLink(_ context.Context, id string) (string, error) {
+	switch {
+	case x.subscriptionURL == "":
+		return id, nil
+	case strings.Contains(x.subscriptionURL, "{key}"):
+		return strings.ReplaceAll(x.subscriptionURL, "{key}", id), nil
+	}
+	return x.subscriptionURL + id, nil
+}
+
+// OnlineClients maps the emails 3x-ui reports online to client IDs.
+func (x *XUI) OnlineClients(ctx context.Context) ([]string, error) {
+	emails, err := x.client.OnlineEmails(ctx)
+	if err != nil || len(emails) == 0 {
+		return nil, err
+	}
+	infos, err := x.client.ListClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]string, len(infos))
+	for _, info := range infos {
+		ids[info.Email] = info.ID
+	}
+	online := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if id, ok := ids[email]; ok {
+			online = append(online, id)
+		}
+	}
+	return online, nil
+}
+
+func (x *XUI) ClientIPs(ctx context.Context, id string) ([]ClientIP, error) {
+	info, err := x.client.GetClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := x.client.ClientIPs(ctx, info.Email)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]ClientIP, 0, len(entries))
+	for _, e := range entries {
+		ips = append(ips, ClientIP{IP: e.IP, SeenAt: e.SeenAt})
+	}
+	return ips, nil
+}
+
+func (x *XUI) ClientLimitIP(ctx context.Context, id string) (int, error) {
+	return x.client.ClientLimitIP(ctx, id)
+}
+
+func fromXUI(info panel.ClientInfo) Client {
+	return Client{
+		ID:         info.ID,
+		Email:      info.Email,
+		Enable:     info.Enable,
+		Expiry:     info.Expiry,
+		TotalBytes: info.TotalBytes,
+		Up:         info.Up,
+		Down:       info.Down,
+		CreatedAt:  info.CreatedAt,
+	}
+}
@@ -0,0 +1,260 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+// Source says which side wins when the database and the panel disagree.
+type Source string
+
+const (
+	// SourceNone only reports differences.
+	SourceNone Source = "none"
+	// SourceDB deletes orphaned clients the bot created and pushes
+	// database expiries to the panel.
+	SourceDB Source = "db"
+	// SourcePanel forgets keys missing from the panel and copies panel
+	// expiries to the database.
+	SourcePanel Source = "panel"
+)
+
+func ParseSource(s string) (Source, error) {
+	switch Source(s) {
+	case "", SourceNone:
+		return SourceNone, nil
+	case SourceDB, SourcePanel:
+		return Source(s), nil
+	}
+	return "", fmt.Errorf("unknown reconcile source %q", s)
+}
+
+// expiryTolerance absorbs rounding of expiries to seconds.
+const expiryTolerance = time.Minute
+
+// orphanGrace protects clients just created by the bot, whose key is
+// written to the database after the panel call.
+const orphanGrace = 15 * time.Minute
+
+// botClientName matches the names the bot gives its clients, such as
+// user-5@example.com or user-5-dev-1700000000@example.com (user_5 on
+// Marzban), capturing the user ID.
+var botClientName = regexp.MustCompile(`^user[-_](\d+)(?:[-_@]|$)`)
+
+type Mismatch struct {
+	Key         storage.KeyRecord
+	PanelExpiry time.Time
+}
+
+type Report struct {
+	// Orphans are panel clients unknown to the database.
+	Orphans []provision.Client
+	// Missing are database keys without a panel client.
+	Missing    []storage.KeyRecord
+	Mismatched []Mismatch
+	Repaired   int
+	Failed     int
+	// Skipped counts orphans left alone because the bot did not create
+	// them or may still be storing them.
+	Skipped int
+	// Unreachable are the servers that could not be listed; their keys
+	// were not checked.
+	Unreachable provision.ServerErrors
+}
+
+// Clean reports whether the database and the panel agree on every server.
+func (r *Report) Clean() bool {
+	return len(r.Orphans) == 0 && len(r.Missing) == 0 && len(r.Mismatched) == 0 && len(r.Unreachable) == 0
+}
+
+type Reconciler struct {
+	panel  provision.Provisioner
+	store  *storage.Storage
+	source Source
+}
+
+func New(provisioner provision.Provisioner, store *storage.Storage, source Source) *Reconciler {
+	return &Reconciler{panel: provisioner, store: store, source: source}
+}
+
+func (r *Reconciler) Source() Source {
+	return r.source
+}
+
+// Run compares panel clients with the keys in the database. With repair set
+// the differences are fixed according to the configured source of truth.
+// Servers that cannot be listed are reported and their keys left alone.
+func (r *Reconciler) Run(ctx context.Context, repair bool) (*Report, error) {
+	report := &Report{}
+	clients, err := r.panel.ListClients(ctx)
+	if errors.As(err, &report.Unreachable) {
+		log.Printf("reconcile: %v", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("list panel clients: %w", err)
+	}
+	keys, err := r.store.ListKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+
+	byID := make(map[string]provision.Client, len(clients))
+	for _, c := range clients {
+		byID[c.ID] = c
+	}
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		known[k.KeyID] = true
+		if r.unreachable(k.KeyID, report.Unreachable) {
+			continue
+		}
+		c, ok := byID[k.KeyID]
+		if !ok {
+			report.Missing = append(report.Missing, k)
+			continue
+		}
+		if !sameExpiry(k.ExpiresAt, c.Expiry, r.tolerance(k.KeyID)) {
+			report.Mismatched = append(report.Mismatched, Mismatch{Key: k, PanelExpiry: c.Expiry})
+		}
+	}
+	for _, c := range clients {
+		if !known[c.ID] {
+			report.Orphans = append(report.Orphans, c)
+		}
+	}
+
+	if repair {
+		r.repair(ctx, report)
+	}
+	return report, nil
+}
+
+// unreachable reports whether the key's server could not be listed.
+func (r *Reconciler) unreachable(keyID string, failed provision.ServerErrors) bool {
+	l, ok := r.panel.(provision.Locator)
+	if !ok || len(failed) == 0 {
+		return false
+	}
+	_, down := failed[l.ServerOf(keyID)]
+	return down
+}
+
+// precision returns the unit the key's server truncates expiries to, zero
+// if it keeps them to the second.
+func (r *Reconciler) precision(keyID string) time.Duration {
+	if p, ok := r.panel.(provision.Precision); ok {
+		return p.ExpiryPrecision(keyID)
+	}
+	return 0
+}
+
+// tolerance is how far the key's expiries may differ while matching.
+func (r *Reconciler) tolerance(keyID string) time.Duration {
+	if p := r.precision(keyID); p > expiryTolerance {
+		return p
+	}
+	return expiryTolerance
+}
+
+func sameExpiry(db sql.NullTime, panelExpiry time.Time, tolerance time.Duration) bool {
+	if !db.Valid || panelExpiry.IsZero() {
+		return !db.Valid && panelExpiry.IsZero()
+	}
+	d := db.Time.Sub(panelExpiry)
+	return d < tolerance && d > -tolerance
+}
+
+func (r *Reconciler) repair(ctx context.Context, report *Report) {
+	count := func(what string, err error) {
+		if err != nil {
+			log.Printf("reconcile %s: %v", what, err)
+			report.Failed++
+			return
+		}
+		report.Repaired++
+	}
+
+	switch r.source {
+	case SourceDB:
+		for _, c := range report.Orphans {
+			deleted, err := r.deleteOrphan(ctx, c)
+			if err == nil && !deleted {
+				report.Skipped++
+				continue
+			}
+			count("delete orphan "+c.ID, err)
+		}
+		for _, m := range report.Mismatched {
+			if !m.Key.ExpiresAt.Valid {
+				// The panel treats a zero expiry as unlimited; the
+				// database has no such value to push.
+				report.Failed++
+				continue
+			}
+			err := r.panel.SetClientExpiry(ctx, m.Key.KeyID, m.Key.ExpiresAt.Time)
+			if err == nil && m.Key.Suspended {
+				// Setting the expiry enabled the key.
+				err = r.panel.DisableClient(ctx, m.Key.KeyID)
+			}
+			count("set expiry of "+m.Key.KeyID, err)
+		}
+		// Missing clients cannot be recreated with the same key, so they
+		// are left for an admin.
+	case SourcePanel:
+		for _, k := range report.Missing {
+			count("forget key "+k.KeyID, r.store.ForgetKey(ctx, k))
+		}
+		for _, m := range report.Mismatched {
+			if m.Key.DeviceID != 0 {
+				// Device keys share the user's expiry, which is taken
+				// from the main key only.
+				continue
+			}
+			expiry := sql.NullTime{Time: m.PanelExpiry, Valid: !m.PanelExpiry.IsZero()}
+			if p := r.precision(m.Key.KeyID); p > 0 && expiry.Valid && m.Key.ExpiresAt.Valid {
+				// Keep the part of the database expiry the panel drops,
+				// such as the time of day, rather than rounding it.
+				db := m.Key.ExpiresAt.Time
+				expiry.Time = m.PanelExpiry.Truncate(p).Add(db.Sub(db.Truncate(p)))
+			}
+			count(fmt.Sprintf("set expiry of user %d", m.Key.UserID), r.store.SetUserExpiry(ctx, m.Key.UserID, expiry))
+		}
+		// Orphans carry no reliable owner, so they are left for an admin.
+	}
+}
+
+// deleteOrphan deletes a client the bot created but no longer knows about.
+// Clients named otherwise, created within orphanGrace, or whose user is
+// busy are skipped. Under the user's lock the key is looked up again, since
+// the bot creates a client before storing its key.
+func (r *Reconciler) deleteOrphan(ctx context.Context, c provision.Client) (bool, error) {
+	m := botClientName.FindStringSubmatch(c.Email)
+	if m == nil {
+		return false, nil
+	}
+	if !c.CreatedAt.IsZero() && time.Since(c.CreatedAt) < orphanGrace {
+		return false, nil
+	}
+	userID, err := strconv.Atoi(m[1])
+	if err != nil {
+		return false, nil
+	}
+	unlock, ok, err := r.store.TryLockUser(ctx, userID)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer unlock()
+	known, err := r.store.HasKey(ctx, c.ID)
+	if err != nil || known {
+		return false, err
+	}
+	return true, r.panel.DeleteClient(ctx, c.ID)
+}
@@ -12,6 +12,22 @@ type Notifier interface {
 	NotifyRenewal(ctx context.Context, when time.Time) error
 }
 
+type Reconciler interface {
+	Reconcile(ctx context.Context) error
+}
+
+type RenewalRunner interface {
+	RetryRenewals(ctx context.Context) error
+}
+
+type SharingChecker interface {
+	CheckSharing(ctx context.Context) error
+}
+
+type ClientExpirer interface {
+	ExpireClients(ctx context.Context) (int, error)
+}
+
 type Scheduler struct {
 	cron *cron.Cron
 }
@@ -37,3 +53,43 @@ func (s *Scheduler) ScheduleDailyNotifications(n Notifier) error {
 	})
 	return err
 }
+
+func (s *Scheduler) ScheduleReconciliation(spec string, r Reconciler) error {
+	_, err := s.cron.AddFunc(spec, func() {
+		ctx := context.Background()
+		if err := r.Reconcile(ctx); err != nil {
+			log.Printf("reconcile: %v", err)
+		}
+	})
+	return err
+}
+
+func (s *Scheduler) ScheduleRenewalRetries(r RenewalRunner) error {
+	_, err := s.cron.AddFunc("@every 1m", func() {
+		// Errors are logged by the runner.
+		_ = r.RetryRenewals(context.Background())
+	})
+	return err
+}
+
+func (s *Scheduler) ScheduleClientExpiry(e ClientExpirer) error {
+	_, err := s.cron.AddFunc("@every 10m", func() {
+		n, err := e.ExpireClients(context.Background())
+		if n > 0 {
+			log.Printf("expired %d clients", n)
+		}
+		if err != nil {
+			log.Printf("expire clients: %v", err)
+		}
+	})
+	return err
+}
+
+func (s *Scheduler) ScheduleSharingChecks(spec string, c SharingChecker) error {
+	_, err := s.cron.AddFunc(spec, func() {
+		if err := c.CheckSharing(context.Background()); err != nil {
+			log.Printf("check sharing: %v", err)
+		}
+	})
+	return err
+}
@@ -0,0 +1,94 @@
+package sharing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// asnCacheTTL is how long the ASN of a network is remembered.
+const asnCacheTTL = 24 * time.Hour
+
+type asnEntry struct {
+	asn     int
+	expires time.Time
+}
+
+// asnResolver finds the ASN announcing an address through the Team Cymru
+// IP-to-ASN DNS service, caching results by network.
+type asnResolver struct {
+	resolver *net.Resolver
+
+	mu    sync.Mutex
+	cache map[string]asnEntry
+}
+
+func newASNResolver() *asnResolver {
+	return &asnResolver{resolver: net.DefaultResolver, cache: make(map[string]asnEntry)}
+}
+
+// lookup returns the ASN of ip, or zero if it cannot be found.
+func (r *asnResolver) lookup(ctx context.Context, ip, network string) int {
+	r.mu.Lock()
+	if e, ok := r.cache[network]; ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.asn
+	}
+	r.mu.Unlock()
+
+	name, err := cymruName(ip)
+	if err != nil {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	records, err := r.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		log.Printf("asn lookup %s: %v", ip, err)
+		return 0
+	}
+	asn := 0
+	for _, rec := range records {
+		// "13335 | 1.1.1.0/24 | US | arin | 2010-07-14"; the first field
+		// may list several ASNs.
+		fields := strings.Fields(strings.SplitN(rec, "|", 2)[0])
+		if len(fields) > 0 {
+			if n, err := strconv.Atoi(fields[0]); err == nil {
+				asn = n
+				break
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.cache[network] = asnEntry{asn: asn, expires: time.Now().Add(asnCacheTTL)}
+	r.mu.Unlock()
+	return asn
+}
+
+// cymruName returns the query name for ip: its reversed octets under
+// origin.asn.cymru.com, or reversed nibbles under origin6.asn.cymru.com.
+func cymruName(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		b := addr.As4()
+		return fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", b[3], b[2], b[1], b[0]), nil
+	}
+	b := addr.As16()
+	var sb strings.Builder
+	for i := len(b) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "%x.%x.", b[i]&0x0f, b[i]>>4)
+	}
+	sb.WriteString("origin6.asn.cymru.com")
+	return sb.String(), nil
+}
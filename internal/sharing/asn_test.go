@@ -0,0 +1,22 @@
+package sharing
+
+import "testing"
+
+func TestCymruName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+		ok   bool
+	}{
+		{"1.2.3.4", "4.3.2.1.origin.asn.cymru.com", true},
+		{"::ffff:1.2.3.4", "4.3.2.1.origin.asn.cymru.com", true},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.origin6.asn.cymru.com", true},
+		{"not an ip", "", false},
+	}
+	for _, tt := range tests {
+		got, err := cymruName(tt.ip)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("cymruName(%q) = %q, %v; want %q, ok %v", tt.ip, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package sharing detects keys used from more networks than a plan allows.
+package sharing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"vpn-bot/internal/provision"
+	"vpn-bot/internal/storage"
+)
+
+// Action is what happens to a user flagged for sharing.
+type Action string
+
+const (
+	ActionNone    Action = "none"
+	ActionWarn    Action = "warn"
+	ActionSuspend Action = "suspend"
+)
+
+// ParseAction parses SHARING_ACTION; empty means ActionNone.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case "":
+		return ActionNone, nil
+	case ActionNone, ActionWarn, ActionSuspend:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown sharing action %q, want none, warn or suspend", s)
+}
+
+// Options configure detection.
+type Options struct {
+	// Window is how far back connections are counted.
+	Window time.Duration
+	// Tolerance is how many networks beyond the plan's device count are
+	// allowed, for devices moving between networks.
+	Tolerance int
+	// ByASN counts networks of one provider as one, so a phone switching
+	// between a carrier's address ranges is not flagged.
+	ByASN  bool
+	Action Action
+}
+
+// Suspect is a flagged user with the samples that show it.
+type Suspect struct {
+	storage.SharingSuspect
+	Samples []storage.IPSample
+}
+
+type Detector struct {
+	monitor provision.Monitor
+	store   *storage.Storage
+	opts    Options
+	asn     *asnResolver
+
+	mu sync.Mutex
+	// sampled holds the keys whose address log was read since the start.
+	// Undated entries found on the first read could be of any age.
+	sampled map[string]bool
+}
+
+func New(monitor provision.Monitor, store *storage.Storage, opts Options) *Detector {
+	d := &Detector{monitor: monitor, store: store, opts: opts, sampled: make(map[string]bool)}
+	if opts.ByASN {
+		d.asn = newASNResolver()
+	}
+	return d
+}
+
+func (d *Detector) Action() Action {
+	return d.opts.Action
+}
+
+func (d *Detector) Window() time.Duration {
+	return d.opts.Window
+}
+
+// Sample records the addresses of the clients connected now and prunes
+// samples older than the window. The panel's address log is left alone, as
+// the panel enforces its own IP limits with it. Undated entries are recorded
+// as seen now when they first appear in the log; those already there when a
+// key is first read, of unknown age, only mark the address as known.
+func (d *Detector) Sample(ctx context.Context) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	windowStart := now.Add(-d.opts.Window)
+	if err := d.store.PruneIPSamples(ctx, windowStart); err != nil {
+		return 0, fmt.Errorf("prune samples: %w", err)
+	}
+
+	online, err := d.monitor.OnlineClients(ctx)
+	if err != nil || len(online) == 0 {
+		return 0, err
+	}
+	keys, err := d.store.ListKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	owners := make(map[string]int, len(keys))
+	for _, k := range keys {
+		owners[k.KeyID] = k.UserID
+	}
+
+	recorded := 0
+	for _, keyID := range online {
+		userID, ok := owners[keyID]
+		if !ok {
+			continue
+		}
+		ips, err := d.monitor.ClientIPs(ctx, keyID)
+		if err != nil {
+			log.Printf("client IPs of %s: %v", keyID, err)
+			continue
+		}
+		logged, err := d.store.LoggedIPs(ctx, keyID)
+		if err != nil {
+			return recorded, fmt.Errorf("logged IPs of %s: %w", keyID, err)
+		}
+		// A key with known log entries was read before a restart.
+		first := !d.sampled[keyID] && len(logged) == 0
+		var undated []string
+		for _, ip := range ips {
+			network, err := Network(ip.IP)
+			if err != nil {
+				continue
+			}
+			sample := storage.IPSample{UserID: userID, KeyID: keyID, IP: ip.IP, Network: network, LastSeenAt: ip.SeenAt}
+			if ip.SeenAt.IsZero() {
+				undated = append(undated, ip.IP)
+				if logged[ip.IP] {
+					continue
+				}
+				sample.InLog = true
+				if !first {
+					sample.LastSeenAt = now
+				}
+			}
+			if sample.LastSeenAt.After(now) {
+				sample.LastSeenAt = now
+			}
+			if !sample.InLog && sample.LastSeenAt.Before(windowStart) {
+				continue
+			}
+			if d.asn != nil && !sample.LastSeenAt.IsZero() {
+				sample.ASN = d.asn.lookup(ctx, ip.IP, network)
+			}
+			if err := d.store.RecordIPSample(ctx, sample); err != nil {
+				return recorded, fmt.Errorf("record sample: %w", err)
+			}
+			if !sample.LastSeenAt.Before(windowStart) {
+				recorded++
+			}
+		}
+		if err := d.store.SetLoggedIPs(ctx, keyID, undated); err != nil {
+			return recorded, fmt.Errorf("logged IPs of %s: %w", keyID, err)
+		}
+		d.sampled[keyID] = true
+	}
+	return recorded, nil
+}
+
+// Suspects returns the users exceeding their plan within the window who
+// have not been flagged in it yet.
+func (d *Detector) Suspects(ctx context.Context) ([]Suspect, error) {
+	since := time.Now().Add(-d.opts.Window)
+	list, err := d.store.ListSharingSuspects(ctx, since, d.opts.Tolerance, d.opts.ByASN)
+	if err != nil {
+		return nil, err
+	}
+	suspects := make([]Suspect, 0, len(list))
+	for _, s := range list {
+		samples, err := d.store.ListIPSamples(ctx, s.UserID, since)
+		if err != nil {
+			return nil, err
+		}
+		suspects = append(suspects, Suspect{SharingSuspect: s, Samples: samples})
+	}
+	return suspects, nil
+}
+
+// Network returns the /24 of an IPv4 or the /48 of an IPv6 address, which
+// stands for one subscriber line or site.
+func Network(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
@@ -0,0 +1,46 @@
+package sharing
+
+import "testing"
+
+func TestNetwork(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+		ok   bool
+	}{
+		{"203.0.113.7", "203.0.113.0/24", true},
+		{"203.0.113.255", "203.0.113.0/24", true},
+		{"::ffff:203.0.113.7", "203.0.113.0/24", true},
+		{"2001:db8:1:2::1", "2001:db8:1::/48", true},
+		{"2001:db8:1:ffff::1", "2001:db8:1::/48", true},
+		{"", "", false},
+		{"203.0.113", "", false},
+		{"No IP Record", "", false},
+	}
+	for _, tt := range tests {
+		got, err := Network(tt.ip)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("Network(%q) = %q, %v; want %q, ok %v", tt.ip, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Action
+		ok   bool
+	}{
+		{"", ActionNone, true},
+		{"none", ActionNone, true},
+		{"warn", ActionWarn, true},
+		{"suspend", ActionSuspend, true},
+		{"ban", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseAction(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseAction(%q) = %q, %v; want %q, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Segment selects a group of users for broadcasts.
+type Segment string
+
+const (
+	SegmentAll       Segment = "all"
+	SegmentActive    Segment = "active"
+	SegmentExpired   Segment = "expired"
+	SegmentExpiring  Segment = "expiring"
+	SegmentNeverPaid Segment = "unpaid"
+)
+
+type Broadcast struct {
+	ID                int
+	AdminID           int64
+	Text              sql.NullString
+	PhotoFileID       sql.NullString
+	Buttons           sql.NullString
+	Segment           Segment
+	SegmentDays       int
+	Status            string
+	ProgressMessageID sql.NullInt64
+	CreatedAt         time.Time
+	FinishedAt        sql.NullTime
+}
+
+type BroadcastRecipient struct {
+	UserID     int
+	TelegramID int64
+}
+
+type BroadcastStats struct {
+	Total   int
+	Pending int
+	Sent    int
+	Failed  int
+	Blocked int
+}
+
+const broadcastColumns = `id, admin_id, text, photo_file_id, buttons, segment, segment_days, status, progress_message_id, created_at, finished_at`
+
+func scanBroadcast(row interface{ Scan(...interface{}) error }) (*Broadcast, error) {
+	var b Broadcast
+	if err := row.Scan(&b.ID, &b.AdminID, &b.Text, &b.PhotoFileID, &b.Buttons, &b.Segment, &b.SegmentDays, &b.Status, &b.ProgressMessageID, &b.CreatedAt, &b.FinishedAt); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// segmentCondition returns the WHERE clause for the segment. argN is the
+// placeholder index used for the day count of SegmentExpiring.
+func segmentCondition(segment Segment, argN int) (string, error) {
+	switch segment {
+	case SegmentAll:
+		return `TRUE`, nil
+	case SegmentActive:
+		return `u.expires_at > now()`, nil
+	case SegmentExpired:
+		return `u.expires_at <= now()`, nil
+	case SegmentExpiring:
+		return fmt.Sprintf(`u.expires_at BETWEEN now() AND now() + make_interval(days => $%d)`, argN), nil
+	case SegmentNeverPaid:
+		return `NOT EXISTS (SELECT 1 FROM payments p WHERE p.user_id = u.id AND p.status = 'confirmed')`, nil
+	}
+	return "", fmt.Errorf("unknown segment %q", segment)
+}
+
+func segmentArgs(segment Segment, days int) []interface{} {
+	if segment == SegmentExpiring {
+		return []interface{}{days}
+	}
+	return nil
+}
+
+// CountSegment returns the number of reachable users in the segment.
+func (s *Storage) CountSegment(ctx context.Context, segment Segment, days int) (int, error) {
+	cond, err := segmentCondition(segment, 1)
+	if err != nil {
+		return 0, err
+	}
+	query := `SELECT count(*) FROM users u WHERE u.status <> 'inactive' AND ` + cond
+	var n int
+	err = s.db.QueryRowContext(ctx, query, segmentArgs(segment, days)...).Scan(&n)
+	return n, err
+}
+
+// CreateBroadcast stores the broadcast and snapshots its recipients so that
+// delivery can be resumed after a restart.
+func (s *Storage) CreateBroadcast(ctx context.Context, b *Broadcast) (*Broadcast, error) {
+	cond, err := segmentCondition(b.Segment, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `INSERT INTO broadcasts (admin_id, text, photo_file_id, buttons, segment, segment_days)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING `+broadcastColumns, b.AdminID, b.Text, b.PhotoFileID, b.Buttons, b.Segment, b.SegmentDays)
+	created, err := scanBroadcast(row)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]interface{}{created.ID}, segmentArgs(b.Segment, b.SegmentDays)...)
+	query := `INSERT INTO broadcast_recipients (broadcast_id, user_id)
+SELECT $1, u.id FROM users u WHERE u.status <> 'inactive' AND ` + cond
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *Storage) GetBroadcast(ctx context.Context, id int) (*Broadcast, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+broadcastColumns+` FROM broadcasts WHERE id=$1`, id)
+	return scanBroadcast(row)
+}
+
+func (s *Storage) ListBroadcastsByStatus(ctx context.Context, status string) ([]Broadcast, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+broadcastColumns+` FROM broadcasts WHERE status=$1 ORDER BY id`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Broadcast
+	for rows.Next() {
+		b, err := scanBroadcast(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *b)
+	}
+	return list, rows.Err()
+}
+
+func (s *Storage) SetBroadcastProgressMessage(ctx context.Context, id int, messageID int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE broadcasts SET progress_message_id=$1 WHERE id=$2`, messageID, id)
+	return err
+}
+
+func (s *Storage) FinishBroadcast(ctx context.Context, id int, status string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE broadcasts SET status=$1, finished_at=now() WHERE id=$2`, status, id)
+	return err
+}
+
+func (s *Storage) ListPendingRecipients(ctx context.Context, broadcastID int, limit int) ([]BroadcastRecipient, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT r.user_id, u.telegram_id
+FROM broadcast_recipients r JOIN users u ON u.id = r.user_id
+WHERE r.broadcast_id=$1 AND r.status='pending'
+ORDER BY r.user_id
+LIMIT $2`, broadcastID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []BroadcastRecipient
+	for rows.Next() {
+		var r BroadcastRecipient
+		if err := rows.Scan(&r.UserID, &r.TelegramID); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (s *Storage) UpdateRecipientStatus(ctx context.Context, broadcastID, userID int, status string, errText *string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE broadcast_recipients SET status=$1, error=$2, sent_at=now() WHERE broadcast_id=$3 AND user_id=$4`, status, errText, broadcastID, userID)
+	return err
+}
+
+func (s *Storage) GetBroadcastStats(ctx context.Context, broadcastID int) (*BroadcastStats, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT status, count(*) FROM broadcast_recipients WHERE broadcast_id=$1 GROUP BY status`, broadcastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var st BroadcastStats
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		st.Total += n
+		switch status {
+		case "pending":
+			st.Pending = n
+		case "sent":
+			st.Sent = n
+		case "failed":
+			st.Failed = n
+		case "blocked":
+			st.Blocked = n
+		}
+	}
+	return &st, rows.Err()
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+type Plan struct {
+	ID      int
+	Code    string
+	Title   string
+	Days    int
+	Devices int
+	// FamilyMembers is how many other users the owner may invite.
+	FamilyMembers int
+}
+
+// Device is an additional key of a user. The first key is User.KeyID.
+type Device struct {
+	ID        int
+	UserID    int
+	Name      string
+	KeyID     string
+	CreatedAt time.Time
+}
+
+const planColumns = `id, code, title, days, devices, family_members`
+
+func scanPlan(row interface{ Scan(...interface{}) error }) (*Plan, error) {
+	var p Plan
+	if err := row.Scan(&p.ID, &p.Code, &p.Title, &p.Days, &p.Devices, &p.FamilyMembers); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Storage) ListPlans(ctx context.Context) ([]Plan, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+planColumns+` FROM plans ORDER BY devices, days`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plans []Plan
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, *p)
+	}
+	return plans, rows.Err()
+}
+
+// GetUserPlan returns the user's plan, or the default plan if none is set.
+func (s *Storage) GetUserPlan(ctx context.Context, userID int) (*Plan, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+planColumns+` FROM plans
+WHERE id = COALESCE((SELECT plan_id FROM users WHERE id=$1), (SELECT id FROM plans WHERE is_default))`, userID)
+	return scanPlan(row)
+}
+
+// SetUserPlan assigns the plan with the given code and reports whether it
+// exists.
+func (s *Storage) SetUserPlan(ctx context.Context, userID int, code string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET plan_id=(SELECT id FROM plans WHERE code=$1)
+WHERE id=$2 AND EXISTS (SELECT 1 FROM plans WHERE code=$1)`, code, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetUserPlanID assigns the plan with the given ID.
+func (s *Storage) SetUserPlanID(ctx context.Context, userID, planID int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET plan_id=$1 WHERE id=$2`, planID, userID)
+	return err
+}
+
+func (s *Storage) ListDevices(ctx context.Context, userID int) ([]Device, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, name, key_id, created_at FROM devices WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var devices []Device
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Name, &d.KeyID, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+func (s *Storage) GetDevice(ctx context.Context, id int) (*Device, error) {
+	var d Device
+	err := s.db.QueryRowContext(ctx, `SELECT id, user_id, name, key_id, created_at FROM devices WHERE id=$1`, id).
+		Scan(&d.ID, &d.UserID, &d.Name, &d.KeyID, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *Storage) AddDevice(ctx context.Context, userID int, name, keyID string) (*Device, error) {
+	d := Device{UserID: userID, Name: name, KeyID: keyID}
+	err := s.db.QueryRowContext(ctx, `INSERT INTO devices (user_id, name, key_id) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		userID, name, keyID).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *Storage) DeleteDevice(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM devices WHERE id=$1`, id)
+	return err
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrInviteInvalid is returned for unknown, used or expired family invites.
+var ErrInviteInvalid = errors.New("family invite is invalid")
+
+func (s *Storage) CreateFamilyInvite(ctx context.Context, ownerID int, code string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO family_invites (code, owner_id, expires_at) VALUES ($1, $2, $3)`, code, ownerID, expiresAt)
+	return err
+}
+
+// GetFamilyInviteOwner returns the owner of a usable invite.
+func (s *Storage) GetFamilyInviteOwner(ctx context.Context, code string) (int, error) {
+	var ownerID int
+	err := s.db.QueryRowContext(ctx, `SELECT owner_id FROM family_invites WHERE code=$1 AND used_at IS NULL AND expires_at > now()`, code).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInviteInvalid
+	}
+	return ownerID, err
+}
+
+// JoinFamily uses the invite and gives the member their key in the owner's
+// family. The member must have no key or an expired one, oldKeyID, which is
+// replaced; their device keys are dropped too.
+func (s *Storage) JoinFamily(ctx context.Context, code string, memberID int, oldKeyID sql.NullString, keyID string, expiresAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	err = tx.QueryRowContext(ctx, `UPDATE family_invites SET used_by=$2, used_at=now()
+WHERE code=$1 AND used_at IS NULL AND expires_at > now() AND owner_id<>$2
+RETURNING owner_id`, code, memberID).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInviteInvalid
+	}
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE users SET key_id=$1, expires_at=$2, family_owner_id=$3
+WHERE id=$4 AND key_id IS NOT DISTINCT FROM $5 AND (key_id IS NULL OR expires_at <= now())`,
+		keyID, expiresAt, ownerID, memberID, oldKeyID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrInviteInvalid
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM devices WHERE user_id=$1`, memberID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) ListFamilyMembers(ctx context.Context, ownerID int) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE family_owner_id=$1 ORDER BY id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// RemoveFamilyMember detaches the member and clears their key. It reports
+// whether the user was a member of the owner's family.
+func (s *Storage) RemoveFamilyMember(ctx context.Context, ownerID, memberID int) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET family_owner_id=NULL, key_id=NULL, expires_at=NULL WHERE id=$1 AND family_owner_id=$2`,
+		memberID, ownerID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrGiftInvalid is returned for unknown or already redeemed gift codes.
+var ErrGiftInvalid = errors.New("gift code is invalid")
+
+type Gift struct {
+	Code       string
+	BuyerID    int
+	PaymentID  int
+	PlanID     int
+	Days       int
+	CreatedAt  time.Time
+	RedeemedBy sql.NullInt64
+	RedeemedAt sql.NullTime
+}
+
+const giftColumns = `code, buyer_id, payment_id, plan_id, days, created_at, redeemed_by, redeemed_at`
+
+func scanGift(row interface{ Scan(...interface{}) error }) (*Gift, error) {
+	var g Gift
+	if err := row.Scan(&g.Code, &g.BuyerID, &g.PaymentID, &g.PlanID, &g.Days, &g.CreatedAt, &g.RedeemedBy, &g.RedeemedAt); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// CreateGift confirms the payment and stores the gift code it buys in one
+// transaction. It returns ErrPaymentProcessed if the payment was already
+// confirmed or rejected.
+func (s *Storage) CreateGift(ctx context.Context, code string, buyerID, paymentID, planID, days int) (*Gift, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := claimPayment(ctx, tx, paymentID); err != nil {
+		return nil, err
+	}
+	row := tx.QueryRowContext(ctx, `INSERT INTO gift_codes (code, buyer_id, payment_id, plan_id, days)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING `+giftColumns, code, buyerID, paymentID, planID, days)
+	g, err := scanGift(row)
+	if err != nil {
+		return nil, err
+	}
+	return g, tx.Commit()
+}
+
+// RedeemGift marks the gift as redeemed by the user. Buyers cannot redeem
+// their own gifts.
+func (s *Storage) RedeemGift(ctx context.Context, code string, userID int) (*Gift, error) {
+	row := s.db.QueryRowContext(ctx, `UPDATE gift_codes SET redeemed_by=$2, redeemed_at=now()
+WHERE code=$1 AND redeemed_at IS NULL AND buyer_id<>$2
+RETURNING `+giftColumns, code, userID)
+	g, err := scanGift(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGiftInvalid
+	}
+	return g, err
+}
+
+// ReleaseGift undoes a redemption that could not be applied.
+func (s *Storage) ReleaseGift(ctx context.Context, code string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE gift_codes SET redeemed_by=NULL, redeemed_at=NULL WHERE code=$1`, code)
+	return err
+}
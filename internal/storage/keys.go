@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// KeyRecord is a panel client the database knows about: a user's main key
+// or one of their device keys, which share the user's expiry.
+type KeyRecord struct {
+	UserID     int
+	TelegramID int64
+	DeviceID   int
+	KeyID      string
+	ExpiresAt  sql.NullTime
+	// Suspended is set when the user's keys are disabled for sharing.
+	Suspended bool
+}
+
+func (s *Storage) ListKeys(ctx context.Context) ([]KeyRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, telegram_id, 0, key_id, expires_at, suspended_at IS NOT NULL FROM users WHERE key_id IS NOT NULL
+UNION ALL
+SELECT u.id, u.telegram_id, d.id, d.key_id, u.expires_at, u.suspended_at IS NOT NULL FROM devices d JOIN users u ON u.id = d.user_id
+ORDER BY 1, 3`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []KeyRecord
+	for rows.Next() {
+		var k KeyRecord
+		if err := rows.Scan(&k.UserID, &k.TelegramID, &k.DeviceID, &k.KeyID, &k.ExpiresAt, &k.Suspended); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// HasKey reports whether a user or device holds the key.
+func (s *Storage) HasKey(ctx context.Context, keyID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE key_id=$1)
+OR EXISTS (SELECT 1 FROM devices WHERE key_id=$1)`, keyID).Scan(&exists)
+	return exists, err
+}
+
+// SetUserExpiry changes the user's expiry without touching the key.
+func (s *Storage) SetUserExpiry(ctx context.Context, userID int, expiresAt sql.NullTime) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET expires_at=$1 WHERE id=$2`, expiresAt, userID)
+	return err
+}
+
+// ForgetKey removes a key the panel no longer has: a device row, or the
+// user's main key.
+func (s *Storage) ForgetKey(ctx context.Context, k KeyRecord) error {
+	if k.DeviceID != 0 {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM devices WHERE id=$1 AND key_id=$2`, k.DeviceID, k.KeyID)
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET key_id=NULL WHERE id=$1 AND key_id=$2`, k.UserID, k.KeyID)
+	return err
+}
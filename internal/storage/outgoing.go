@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type OutgoingMessage struct {
+	ID          int64
+	ChatID      int64
+	Text        sql.NullString
+	PhotoFileID sql.NullString
+	ParseMode   sql.NullString
+	ReplyMarkup sql.NullString
+	Status      string
+	Attempts    int
+	CreatedAt   time.Time
+}
+
+func (s *Storage) EnqueueMessage(ctx context.Context, m *OutgoingMessage) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO outgoing_messages (chat_id, text, photo_file_id, parse_mode, reply_markup)
+VALUES ($1, $2, $3, $4, $5) RETURNING id`, m.ChatID, m.Text, m.PhotoFileID, m.ParseMode, m.ReplyMarkup).Scan(&id)
+	return id, err
+}
+
+// ListDueMessages returns pending messages whose next attempt is due, oldest first.
+func (s *Storage) ListDueMessages(ctx context.Context, limit int) ([]OutgoingMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, chat_id, text, photo_file_id, parse_mode, reply_markup, status, attempts, created_at
+FROM outgoing_messages
+WHERE status='pending' AND next_attempt_at <= now()
+ORDER BY id
+LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []OutgoingMessage
+	for rows.Next() {
+		var m OutgoingMessage
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Text, &m.PhotoFileID, &m.ParseMode, &m.ReplyMarkup, &m.Status, &m.Attempts, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (s *Storage) MarkMessageSent(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE outgoing_messages SET status='sent', sent_at=now(), last_error=NULL WHERE id=$1`, id)
+	return err
+}
+
+// RescheduleMessage postpones a message. countAttempt is false for flood
+// control waits, which should not use up the retry budget.
+func (s *Storage) RescheduleMessage(ctx context.Context, id int64, next time.Time, countAttempt bool, lastErr string) error {
+	inc := 0
+	if countAttempt {
+		inc = 1
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE outgoing_messages SET next_attempt_at=$1, attempts=attempts+$2, last_error=$3 WHERE id=$4`, next, inc, lastErr, id)
+	return err
+}
+
+// DeferMessage moves the next attempt without counting one.
+func (s *Storage) DeferMessage(ctx context.Context, id int64, next time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE outgoing_messages SET next_attempt_at=$1 WHERE id=$2`, next, id)
+	return err
+}
+
+func (s *Storage) FailMessage(ctx context.Context, id int64, status, lastErr string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE outgoing_messages SET status=$1, attempts=attempts+1, last_error=$2 WHERE id=$3`, status, lastErr, id)
+	return err
+}
+
+// DeactivateUserByTelegramID marks the user inactive, e.g. after they blocked the bot.
+func (s *Storage) DeactivateUserByTelegramID(ctx context.Context, telegramID int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET status='inactive' WHERE telegram_id=$1`, telegramID)
+	return err
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Renewal states. A renewal moves pending -> panel_done -> done, or to
+// failed once it runs out of attempts.
+const (
+	RenewalPending   = "pending"
+	RenewalPanelDone = "panel_done"
+	RenewalDone      = "done"
+	RenewalFailed    = "failed"
+)
+
+// ErrPaymentProcessed is returned when a payment is no longer pending.
+var ErrPaymentProcessed = errors.New("payment already processed")
+
+type Renewal struct {
+	ID        int
+	PaymentID int
+	UserID    int
+	ExpiresAt time.Time
+	State     string
+	Attempts  int
+	LastError sql.NullString
+}
+
+const renewalColumns = `id, payment_id, user_id, expires_at, state, attempts, last_error`
+
+func scanRenewal(row interface{ Scan(...interface{}) error }) (*Renewal, error) {
+	var r Renewal
+	if err := row.Scan(&r.ID, &r.PaymentID, &r.UserID, &r.ExpiresAt, &r.State, &r.Attempts, &r.LastError); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// claimPayment confirms a pending payment. It fails with
+// ErrPaymentProcessed if the payment was already confirmed or rejected.
+func claimPayment(ctx context.Context, db interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}, paymentID int) error {
+	res, err := db.ExecContext(ctx, `UPDATE payments SET status='confirmed' WHERE id=$1 AND status='pending'`, paymentID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPaymentProcessed
+	}
+	return nil
+}
+
+// StartRenewal confirms the payment and records the renewal it pays for in
+// one transaction.
+func (s *Storage) StartRenewal(ctx context.Context, paymentID, userID int, expiresAt time.Time) (*Renewal, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := claimPayment(ctx, tx, paymentID); err != nil {
+		return nil, err
+	}
+	row := tx.QueryRowContext(ctx, `INSERT INTO renewals (payment_id, user_id, expires_at) VALUES ($1, $2, $3) RETURNING `+renewalColumns,
+		paymentID, userID, expiresAt)
+	r, err := scanRenewal(row)
+	if err != nil {
+		return nil, err
+	}
+	return r, tx.Commit()
+}
+
+// ListDueRenewals returns unfinished renewals whose next attempt is due.
+func (s *Storage) ListDueRenewals(ctx context.Context, limit int) ([]Renewal, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+renewalColumns+` FROM renewals
+WHERE state IN ('pending', 'panel_done') AND next_attempt_at <= now()
+ORDER BY next_attempt_at LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var renewals []Renewal
+	for rows.Next() {
+		r, err := scanRenewal(rows)
+		if err != nil {
+			return nil, err
+		}
+		renewals = append(renewals, *r)
+	}
+	return renewals, rows.Err()
+}
+
+func (s *Storage) GetRenewal(ctx context.Context, id int) (*Renewal, error) {
+	return scanRenewal(s.db.QueryRowContext(ctx, `SELECT `+renewalColumns+` FROM renewals WHERE id=$1`, id))
+}
+
+func (s *Storage) SetRenewalState(ctx context.Context, id int, state string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE renewals SET state=$1, updated_at=now() WHERE id=$2`, state, id)
+	return err
+}
+
+// RetryRenewal counts a failed attempt and schedules the next one.
+func (s *Storage) RetryRenewal(ctx context.Context, id int, next time.Time, lastErr string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE renewals SET attempts=attempts+1, next_attempt_at=$1, last_error=$2, updated_at=now() WHERE id=$3`,
+		next, lastErr, id)
+	return err
+}
+
+func (s *Storage) FailRenewal(ctx context.Context, id int, lastErr string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE renewals SET state='failed', attempts=attempts+1, last_error=$1, updated_at=now() WHERE id=$2`,
+		lastErr, id)
+	return err
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+)
+
+// LoadPanelSession returns the saved session cookie of a panel, or nil if
+// there is none. Its Expires is zero when the expiry is unknown.
+func (s *Storage) LoadPanelSession(ctx context.Context, panelURL string) (*http.Cookie, error) {
+	var c http.Cookie
+	var expires sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT cookie_name, cookie_value, expires_at FROM panel_sessions WHERE panel_url=$1`, panelURL).
+		Scan(&c.Name, &c.Value, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Expires = expires.Time
+	return &c, nil
+}
+
+func (s *Storage) SavePanelSession(ctx context.Context, panelURL string, c *http.Cookie) error {
+	expires := sql.NullTime{Time: c.Expires, Valid: !c.Expires.IsZero()}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO panel_sessions (panel_url, cookie_name, cookie_value, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (panel_url) DO UPDATE SET cookie_name = EXCLUDED.cookie_name, cookie_value = EXCLUDED.cookie_value,
+    expires_at = EXCLUDED.expires_at, updated_at = now()`,
+		panelURL, c.Name, c.Value, expires)
+	return err
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// IPSample is an address a key was seen connecting from. ASN is zero when
+// unknown.
+type IPSample struct {
+	UserID      int
+	KeyID       string
+	IP          string
+	Network     string
+	ASN         int
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	// InLog is set for undated entries of the panel's address log, which
+	// are sampled once, when they first appear. Entries of unknown age have
+	// a zero LastSeenAt.
+	InLog bool
+}
+
+// SharingSuspect is a user whose keys connected from more networks than
+// their plan has devices.
+type SharingSuspect struct {
+	UserID     int
+	TelegramID int64
+	Devices    int
+	Networks   int
+}
+
+func (s *Storage) RecordIPSample(ctx context.Context, sample IPSample) error {
+	asn := sql.NullInt64{Int64: int64(sample.ASN), Valid: sample.ASN != 0}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO ip_samples (key_id, ip, user_id, network, asn, first_seen_at, last_seen_at, in_log)
+VALUES ($1, $2, $3, $4, $5, $6, $6, $7)
+ON CONFLICT (key_id, ip) DO UPDATE SET last_seen_at = GREATEST(ip_samples.last_seen_at, EXCLUDED.last_seen_at),
+    asn = COALESCE(EXCLUDED.asn, ip_samples.asn), in_log = ip_samples.in_log OR EXCLUDED.in_log`,
+		sample.KeyID, sample.IP, sample.UserID, sample.Network, asn, sample.LastSeenAt, sample.InLog)
+	return err
+}
+
+// ListSharingSuspects returns the users seen since the given time from more
+// than their plan's device count plus tolerance of distinct networks, or of
+// distinct ASNs where known if byASN is set. Users flagged since then are
+// left out.
+func (s *Storage) ListSharingSuspects(ctx context.Context, since time.Time, tolerance int, byASN bool) ([]SharingSuspect, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT u.id, u.telegram_id, p.devices,
+    count(DISTINCT CASE WHEN $3 AND s.asn IS NOT NULL THEN 'AS' || s.asn ELSE s.network END) AS networks
+FROM ip_samples s
+JOIN users u ON u.id = s.user_id
+JOIN plans p ON p.id = COALESCE(u.plan_id, (SELECT id FROM plans WHERE is_default))
+WHERE s.last_seen_at >= $1
+    AND NOT EXISTS (SELECT 1 FROM sharing_flags f WHERE f.user_id = u.id AND f.flagged_at >= $1)
+GROUP BY u.id, u.telegram_id, p.devices
+HAVING count(DISTINCT CASE WHEN $3 AND s.asn IS NOT NULL THEN 'AS' || s.asn ELSE s.network END) > p.devices + $2
+ORDER BY u.id`, since, tolerance, byASN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []SharingSuspect
+	for rows.Next() {
+		var sus SharingSuspect
+		if err := rows.Scan(&sus.UserID, &sus.TelegramID, &sus.Devices, &sus.Networks); err != nil {
+			return nil, err
+		}
+		list = append(list, sus)
+	}
+	return list, rows.Err()
+}
+
+// ListIPSamples returns the user's samples seen since the given time, most
+// recent first.
+func (s *Storage) ListIPSamples(ctx context.Context, userID int, since time.Time) ([]IPSample, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, key_id, ip, network, COALESCE(asn, 0), first_seen_at, last_seen_at
+FROM ip_samples WHERE user_id=$1 AND last_seen_at >= $2 ORDER BY last_seen_at DESC`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []IPSample
+	for rows.Next() {
+		var sample IPSample
+		if err := rows.Scan(&sample.UserID, &sample.KeyID, &sample.IP, &sample.Network, &sample.ASN, &sample.FirstSeenAt, &sample.LastSeenAt); err != nil {
+			return nil, err
+		}
+		list = append(list, sample)
+	}
+	return list, rows.Err()
+}
+
+func (s *Storage) FlagSharing(ctx context.Context, userID, networks int, action string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sharing_flags (user_id, networks, action) VALUES ($1, $2, $3)`, userID, networks, action)
+	return err
+}
+
+func (s *Storage) SuspendUser(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET suspended_at=now() WHERE id=$1`, userID)
+	return err
+}
+
+// UnsuspendUser lifts the suspension and reports whether there was one.
+func (s *Storage) UnsuspendUser(ctx context.Context, userID int) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET suspended_at=NULL WHERE id=$1 AND suspended_at IS NOT NULL`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// PruneIPSamples deletes samples last seen before the given time, except
+// those of addresses still in the panel's log.
+func (s *Storage) PruneIPSamples(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM ip_samples WHERE last_seen_at < $1 AND NOT in_log`, before)
+	return err
+}
+
+// LoggedIPs returns the addresses of the key sampled from undated entries
+// of the panel's log that are still there.
+func (s *Storage) LoggedIPs(ctx context.Context, keyID string) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT ip FROM ip_samples WHERE key_id=$1 AND in_log`, keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ips := make(map[string]bool)
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips[ip] = true
+	}
+	return ips, rows.Err()
+}
+
+// SetLoggedIPs records which undated addresses the key's panel log holds
+// now, so samples of the others can be pruned.
+func (s *Storage) SetLoggedIPs(ctx context.Context, keyID string, ips []string) error {
+	if ips == nil {
+		ips = []string{}
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE ip_samples SET in_log = (ip = ANY($2)) WHERE key_id=$1 AND in_log <> (ip = ANY($2))`, keyID, ips)
+	return err
+}
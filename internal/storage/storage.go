@@ -3,12 +3,16 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// userLockSpace is the first key of per-user advisory locks.
+const userLockSpace = 1
+
 type Storage struct {
 	db *sql.DB
 }
@@ -20,6 +24,14 @@ type User struct {
 	KeyID      sql.NullString
 	ExpiresAt  sql.NullTime
 	Status     string
+	Language   sql.NullString
+	// KeyRotatedAt is when the user last regenerated their key.
+	KeyRotatedAt sql.NullTime
+	TrialUsedAt  sql.NullTime
+	// FamilyOwnerID is the user whose subscription this user shares.
+	FamilyOwnerID sql.NullInt64
+	// SuspendedAt is when the user's keys were disabled for sharing.
+	SuspendedAt sql.NullTime
 }
 
 type Payment struct {
@@ -29,6 +41,27 @@ type Payment struct {
 	Status        string
 	Comment       sql.NullString
 	CreatedAt     time.Time
+	IsGift        bool
+}
+
+const userColumns = `id, telegram_id, username, key_id, expires_at, status, language, key_rotated_at, trial_used_at, family_owner_id, suspended_at`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var u User
+	if err := row.Scan(&u.ID, &u.TelegramID, &u.Username, &u.KeyID, &u.ExpiresAt, &u.Status, &u.Language, &u.KeyRotatedAt, &u.TrialUsedAt, &u.FamilyOwnerID, &u.SuspendedAt); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+const paymentColumns = `id, user_id, screenshot_url, status, comment, created_at, is_gift`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (*Payment, error) {
+	var p Payment
+	if err := row.Scan(&p.ID, &p.UserID, &p.ScreenshotURL, &p.Status, &p.Comment, &p.CreatedAt, &p.IsGift); err != nil {
+		return nil, err
+	}
+	return &p, nil
 }
 
 func New(db *sql.DB) *Storage {
@@ -46,35 +79,24 @@ func Open(dsn string) (*sql.DB, error) {
 func (s *Storage) UpsertUser(ctx context.Context, telegramID int64, username string) (*User, error) {
 	query := `INSERT INTO users (telegram_id, username)
 VALUES ($1, $2)
-ON CONFLICT (telegram_id) DO UPDATE SET username = EXCLUDED.username
-RETURNING id, telegram_id, username, key_id, expires_at, status`
-	row := s.db.QueryRowContext(ctx, query, telegramID, username)
-	var u User
-	if err := row.Scan(&u.ID, &u.TelegramID, &u.Username, &u.KeyID, &u.ExpiresAt, &u.Status); err != nil {
-		return nil, err
-	}
-	return &u, nil
+ON CONFLICT (telegram_id) DO UPDATE SET username = EXCLUDED.username,
+    status = CASE WHEN users.status = 'inactive' THEN 'active' ELSE users.status END
+RETURNING ` + userColumns
+	return scanUser(s.db.QueryRowContext(ctx, query, telegramID, username))
 }
 
 func (s *Storage) GetUserByTelegramID(ctx context.Context, telegramID int64) (*User, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, telegram_id, username, key_id, expires_at, status FROM users WHERE telegram_id=$1`, telegramID)
-	var u User
-	if err := row.Scan(&u.ID, &u.TelegramID, &u.Username, &u.KeyID, &u.ExpiresAt, &u.Status); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE telegram_id=$1`, telegramID)
+	u, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
 	}
-	return &u, nil
+	return u, err
 }
 
 func (s *Storage) GetUserByID(ctx context.Context, id int) (*User, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, telegram_id, username, key_id, expires_at, status FROM users WHERE id=$1`, id)
-	var u User
-	if err := row.Scan(&u.ID, &u.TelegramID, &u.Username, &u.KeyID, &u.ExpiresAt, &u.Status); err != nil {
-		return nil, err
-	}
-	return &u, nil
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id=$1`, id)
+	return scanUser(row)
 }
 
 func (s *Storage) UpdateUserKey(ctx context.Context, userID int, keyID string, expiresAt time.Time) error {
@@ -82,19 +104,68 @@ func (s *Storage) UpdateUserKey(ctx context.Context, userID int, keyID string, e
 	return err
 }
 
+// IssueUserKey stores the first key of a user and reports whether it was
+// stored; it is not when the user already has a key. A trial key also marks
+// the trial as used.
+func (s *Storage) IssueUserKey(ctx context.Context, userID int, keyID string, expiresAt time.Time, trial bool) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE users
+SET key_id=$1, expires_at=$2, trial_used_at=CASE WHEN $4 THEN now() ELSE trial_used_at END
+WHERE id=$3 AND key_id IS NULL`, keyID, expiresAt, userID, trial)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TryLockUser takes a session-level advisory lock on the user so that only
+// one key issuance runs at a time. ok is false if the lock is held elsewhere;
+// otherwise unlock must be called.
+func (s *Storage) TryLockUser(ctx context.Context, userID int) (unlock func(), ok bool, err error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, $2)`, userLockSpace, userID).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, $2)`, userLockSpace, userID); err != nil {
+			// Closing the connection releases the lock anyway.
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
+
+// RotateUserKey replaces the user's key if it is still oldKeyID and records
+// the rotation time. It reports whether the key was replaced.
+func (s *Storage) RotateUserKey(ctx context.Context, userID int, oldKeyID, newKeyID string, expiresAt sql.NullTime) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET key_id=$1, expires_at=$2, key_rotated_at=now() WHERE id=$3 AND key_id=$4`,
+		newKeyID, expiresAt, userID, oldKeyID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
 func (s *Storage) UpdateUserStatus(ctx context.Context, userID int, status string) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE users SET status=$1 WHERE id=$2`, status, userID)
 	return err
 }
 
-func (s *Storage) CreatePayment(ctx context.Context, userID int, screenshotURL string) (*Payment, error) {
-	query := `INSERT INTO payments (user_id, screenshot_url) VALUES ($1, $2) RETURNING id, user_id, screenshot_url, status, comment, created_at`
-	row := s.db.QueryRowContext(ctx, query, userID, screenshotURL)
-	var p Payment
-	if err := row.Scan(&p.ID, &p.UserID, &p.ScreenshotURL, &p.Status, &p.Comment, &p.CreatedAt); err != nil {
-		return nil, err
-	}
-	return &p, nil
+func (s *Storage) SetUserLanguage(ctx context.Context, userID int, lang string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET language=$1 WHERE id=$2`, lang, userID)
+	return err
+}
+
+// CreatePayment records a payment screenshot. A gift payment buys a gift
+// code instead of renewing the payer's subscription.
+func (s *Storage) CreatePayment(ctx context.Context, userID int, screenshotURL string, gift bool) (*Payment, error) {
+	query := `INSERT INTO payments (user_id, screenshot_url, is_gift) VALUES ($1, $2, $3) RETURNING ` + paymentColumns
+	return scanPayment(s.db.QueryRowContext(ctx, query, userID, screenshotURL, gift))
 }
 
 func (s *Storage) UpdatePaymentStatus(ctx context.Context, paymentID int, status string, comment *string) error {
@@ -106,28 +177,31 @@ func (s *Storage) UpdatePaymentStatus(ctx context.Context, paymentID int, status
 	return err
 }
 
+// HasConfirmedPayment reports whether the user has ever paid for themselves.
+func (s *Storage) HasConfirmedPayment(ctx context.Context, userID int) (bool, error) {
+	var ok bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM payments WHERE user_id=$1 AND status='confirmed' AND NOT is_gift)`, userID).Scan(&ok)
+	return ok, err
+}
+
 func (s *Storage) GetPayment(ctx context.Context, paymentID int) (*Payment, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, user_id, screenshot_url, status, comment, created_at FROM payments WHERE id=$1`, paymentID)
-	var p Payment
-	if err := row.Scan(&p.ID, &p.UserID, &p.ScreenshotURL, &p.Status, &p.Comment, &p.CreatedAt); err != nil {
-		return nil, err
-	}
-	return &p, nil
+	return scanPayment(s.db.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE id=$1`, paymentID))
 }
 
 func (s *Storage) ListUsersExpiringBetween(ctx context.Context, from, to time.Time) ([]User, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, telegram_id, username, key_id, expires_at, status FROM users WHERE expires_at BETWEEN $1 AND $2`, from, to)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE expires_at BETWEEN $1 AND $2`, from, to)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var users []User
 	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.TelegramID, &u.Username, &u.KeyID, &u.ExpiresAt, &u.Status); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		users = append(users, *u)
 	}
 	return users, rows.Err()
 }
+
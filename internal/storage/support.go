@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Ticket struct {
+	ID        int
+	UserID    int
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ClosedAt  sql.NullTime
+}
+
+// TicketSummary is an open ticket with its author for admin listings.
+type TicketSummary struct {
+	Ticket
+	TelegramID int64
+	Username   sql.NullString
+	Messages   int
+}
+
+const ticketColumns = `id, user_id, status, created_at, updated_at, closed_at`
+
+func scanTicket(row interface{ Scan(...interface{}) error }) (*Ticket, error) {
+	var t Ticket
+	if err := row.Scan(&t.ID, &t.UserID, &t.Status, &t.CreatedAt, &t.UpdatedAt, &t.ClosedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Storage) CreateTicket(ctx context.Context, userID int) (*Ticket, error) {
+	row := s.db.QueryRowContext(ctx, `INSERT INTO support_tickets (user_id) VALUES ($1) RETURNING `+ticketColumns, userID)
+	return scanTicket(row)
+}
+
+func (s *Storage) GetTicket(ctx context.Context, id int) (*Ticket, error) {
+	return scanTicket(s.db.QueryRowContext(ctx, `SELECT `+ticketColumns+` FROM support_tickets WHERE id=$1`, id))
+}
+
+// GetOpenTicketByUser returns the user's open ticket or nil if there is none.
+func (s *Storage) GetOpenTicketByUser(ctx context.Context, userID int) (*Ticket, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+ticketColumns+` FROM support_tickets WHERE user_id=$1 AND status='open' ORDER BY id DESC LIMIT 1`, userID)
+	t, err := scanTicket(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (s *Storage) SetTicketStatus(ctx context.Context, id int, status string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE support_tickets
+SET status=$1, updated_at=now(), closed_at=CASE WHEN $1='closed' THEN now() ELSE NULL END
+WHERE id=$2`, status, id)
+	return err
+}
+
+func (s *Storage) ListOpenTickets(ctx context.Context) ([]TicketSummary, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT t.id, t.user_id, t.status, t.created_at, t.updated_at, t.closed_at,
+    u.telegram_id, u.username, (SELECT count(*) FROM support_messages m WHERE m.ticket_id = t.id)
+FROM support_tickets t JOIN users u ON u.id = t.user_id
+WHERE t.status='open'
+ORDER BY t.updated_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []TicketSummary
+	for rows.Next() {
+		var t TicketSummary
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Status, &t.CreatedAt, &t.UpdatedAt, &t.ClosedAt, &t.TelegramID, &t.Username, &t.Messages); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *Storage) AddSupportMessage(ctx context.Context, ticketID int, fromAdmin bool, senderID int64, text, photoFileID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO support_messages (ticket_id, from_admin, sender_id, text, photo_file_id)
+VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''))`, ticketID, fromAdmin, senderID, text, photoFileID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE support_tickets SET updated_at=now() WHERE id=$1`, ticketID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) LinkSupportMessage(ctx context.Context, chatID int64, messageID, ticketID int) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO support_links (chat_id, message_id, ticket_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, chatID, messageID, ticketID)
+	return err
+}
+
+// FindTicketByMessage returns the ticket a support chat message belongs to,
+// or 0 if it is not linked to any.
+func (s *Storage) FindTicketByMessage(ctx context.Context, chatID int64, messageID int) (int, error) {
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT ticket_id FROM support_links WHERE chat_id=$1 AND message_id=$2`, chatID, messageID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+type MessageTemplate struct {
+	Key       string
+	Language  string
+	Body      string
+	UpdatedBy int64
+	UpdatedAt time.Time
+}
+
+func (s *Storage) ListMessageTemplates(ctx context.Context) ([]MessageTemplate, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, language, body, coalesce(updated_by, 0), updated_at FROM message_templates`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []MessageTemplate
+	for rows.Next() {
+		var t MessageTemplate
+		if err := rows.Scan(&t.Key, &t.Language, &t.Body, &t.UpdatedBy, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *Storage) UpsertMessageTemplate(ctx context.Context, t MessageTemplate) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO message_templates (key, language, body, updated_by)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (key, language) DO UPDATE SET body = EXCLUDED.body, updated_by = EXCLUDED.updated_by, updated_at = now()`,
+		t.Key, t.Language, t.Body, t.UpdatedBy)
+	return err
+}
+
+func (s *Storage) DeleteMessageTemplate(ctx context.Context, key, language string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM message_templates WHERE key=$1 AND language=$2`, key, language)
+	return err
+}
@@ -0,0 +1,111 @@
+package templates
+
+import (
+	"fmt"
+	"strings"
+)
+
+// allowedTags lists the tags supported by Telegram's HTML parse mode with the
+// attributes each may carry.
+var allowedTags = map[string][]string{
+	"b":          nil,
+	"strong":     nil,
+	"i":          nil,
+	"em":         nil,
+	"u":          nil,
+	"ins":        nil,
+	"s":          nil,
+	"strike":     nil,
+	"del":        nil,
+	"tg-spoiler": nil,
+	"span":       {"class"},
+	"a":          {"href"},
+	"code":       {"class"},
+	"pre":        nil,
+	"blockquote": {"expandable"},
+	"tg-emoji":   {"emoji-id"},
+}
+
+var allowedEntities = map[string]bool{"lt": true, "gt": true, "amp": true, "quot": true}
+
+// ValidateHTML reports markup that Telegram would reject: unknown tags or
+// attributes, unbalanced tags and unescaped special characters.
+func ValidateHTML(s string) error {
+	var stack []string
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '>':
+			return fmt.Errorf("html: unescaped '>' at offset %d, use &gt;", i)
+		case '&':
+			end := strings.IndexByte(s[i:], ';')
+			if end < 0 {
+				return fmt.Errorf("html: unescaped '&' at offset %d, use &amp;", i)
+			}
+			if err := checkEntity(s[i+1 : i+end]); err != nil {
+				return err
+			}
+			i += end
+		case '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return fmt.Errorf("html: unclosed tag at offset %d", i)
+			}
+			tag := s[i+1 : i+end]
+			i += end
+			if strings.HasPrefix(tag, "/") {
+				name := strings.TrimSpace(tag[1:])
+				if len(stack) == 0 || stack[len(stack)-1] != name {
+					return fmt.Errorf("html: unexpected </%s>", name)
+				}
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			name, err := checkTag(tag)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, name)
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("html: <%s> is not closed", stack[len(stack)-1])
+	}
+	return nil
+}
+
+func checkEntity(name string) error {
+	if allowedEntities[name] {
+		return nil
+	}
+	if strings.HasPrefix(name, "#") && len(name) > 1 {
+		return nil
+	}
+	return fmt.Errorf("html: unknown entity &%s;", name)
+}
+
+func checkTag(tag string) (string, error) {
+	name, rest, _ := strings.Cut(strings.TrimSpace(tag), " ")
+	attrs, ok := allowedTags[name]
+	if !ok {
+		return "", fmt.Errorf("html: tag <%s> is not supported", name)
+	}
+	for _, field := range strings.Fields(rest) {
+		attr, _, _ := strings.Cut(field, "=")
+		if !contains(attrs, attr) {
+			return "", fmt.Errorf("html: attribute %q is not allowed in <%s>", attr, name)
+		}
+	}
+	if name == "span" && !strings.Contains(rest, "tg-spoiler") {
+		return "", fmt.Errorf("html: <span> needs class=\"tg-spoiler\"")
+	}
+	return name, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package templates
+
+import "testing"
+
+func TestValidateHTML(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"plain text", true},
+		{"<b>bold</b> and <i>italic</i>", true},
+		{"<b><i>nested</i></b>", true},
+		{`<a href="https://example.com">link</a>`, true},
+		{`<code class="language-go">x</code>`, true},
+		{`<span class="tg-spoiler">hidden</span>`, true},
+		{`<tg-emoji emoji-id="5368324170671202286">👍</tg-emoji>`, true},
+		{"<blockquote expandable>quote</blockquote>", true},
+		{"1 &lt; 2 &amp;&amp; 3 &gt; 2 &quot;ok&quot; &#169;", true},
+		{"", true},
+
+		{"<div>block</div>", false},
+		{`<a onclick="x">link</a>`, false},
+		{"<span>no class</span>", false},
+		{"<b>unclosed", false},
+		{"<b><i>crossed</b></i>", false},
+		{"</b>", false},
+		{"<b", false},
+		{"1 > 0", false},
+		{"fish & chips", false},
+		{"&nbsp;", false},
+	}
+	for _, tt := range tests {
+		err := ValidateHTML(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateHTML(%q) = %v, want ok %v", tt.in, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"vpn-bot/internal/i18n"
+	"vpn-bot/internal/storage"
+)
+
+// Definition describes a message admins may edit and the sample values used
+// to preview it.
+type Definition struct {
+	Key    string
+	Sample i18n.Args
+}
+
+// Editable lists the catalog messages that can be overridden from the bot.
+// They are sent with Telegram HTML formatting.
+var Editable = []Definition{
+	{Key: "start.registered", Sample: i18n.Args{"ID": 42}},
+	{Key: "help.text"},
+	{Key: "guide.ios"},
+	{Key: "guide.android"},
+	{Key: "guide.windows"},
+	{Key: "guide.macos"},
+	{Key: "guide.linux"},
+	{Key: "menu.buy"},
+	{Key: "menu.support"},
+	{Key: "key.issued", Sample: i18n.Args{"Key": "00000000-0000-0000-0000-000000000000", "Expires": "01.01.2030"}},
+	{Key: "payment.confirmed", Sample: i18n.Args{"Expires": "01.01.2030"}},
+	{Key: "renewal.reminder", Sample: i18n.Args{"Expires": "01.01.2030"}},
+}
+
+// Lookup returns the definition of an editable message.
+func Lookup(key string) (Definition, bool) {
+	for _, d := range Editable {
+		if d.Key == key {
+			return d, true
+		}
+	}
+	return Definition{}, false
+}
+
+type cacheKey struct {
+	lang string
+	key  string
+}
+
+// Cache keeps admin-edited templates in memory and implements i18n.Overrides.
+// Edits made through it reload the cache.
+type Cache struct {
+	store   *storage.Storage
+	catalog *i18n.Catalog
+
+	mu     sync.RWMutex
+	bodies map[cacheKey]string
+}
+
+func New(store *storage.Storage, catalog *i18n.Catalog) *Cache {
+	return &Cache{store: store, catalog: catalog, bodies: make(map[cacheKey]string)}
+}
+
+// Load replaces the cached templates with the ones stored in the database.
+func (c *Cache) Load(ctx context.Context) error {
+	list, err := c.store.ListMessageTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	bodies := make(map[cacheKey]string, len(list))
+	for _, t := range list {
+		if _, ok := Lookup(t.Key); !ok {
+			continue
+		}
+		bodies[cacheKey{lang: t.Language, key: t.Key}] = t.Body
+	}
+	c.mu.Lock()
+	c.bodies = bodies
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Cache) Lookup(lang, key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	body, ok := c.bodies[cacheKey{lang: lang, key: key}]
+	return body, ok
+}
+
+// Current returns the effective text of key and whether it was edited.
+func (c *Cache) Current(lang, key string) (string, bool) {
+	if body, ok := c.Lookup(lang, key); ok {
+		return body, true
+	}
+	body, _ := c.catalog.Source(lang, key)
+	return body, false
+}
+
+// Preview renders body with the sample values of key, validating the
+// template and its HTML markup.
+func (c *Cache) Preview(lang, key, body string) (string, error) {
+	def, ok := Lookup(key)
+	if !ok {
+		return "", fmt.Errorf("template %q is not editable", key)
+	}
+	out, err := c.catalog.For(lang).Render(body, def.Sample)
+	if err != nil {
+		return "", fmt.Errorf("template: %w", err)
+	}
+	if err := ValidateHTML(out); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// Set validates and stores a new body for key, then reloads the cache.
+func (c *Cache) Set(ctx context.Context, lang, key, body string, adminID int64) error {
+	if _, err := c.Preview(lang, key, body); err != nil {
+		return err
+	}
+	err := c.store.UpsertMessageTemplate(ctx, storage.MessageTemplate{
+		Key:       key,
+		Language:  lang,
+		Body:      body,
+		UpdatedBy: adminID,
+	})
+	if err != nil {
+		return err
+	}
+	return c.Load(ctx)
+}
+
+// Reset removes the edited body of key so the default is used again.
+func (c *Cache) Reset(ctx context.Context, lang, key string) error {
+	if err := c.store.DeleteMessageTemplate(ctx, key, lang); err != nil {
+		return err
+	}
+	return c.Load(ctx)
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id SERIAL PRIMARY KEY,
+    admin_id BIGINT NOT NULL,
+    text TEXT,
+    photo_file_id TEXT,
+    buttons TEXT,
+    segment TEXT NOT NULL,
+    segment_days INT NOT NULL DEFAULT 0,
+    status TEXT DEFAULT 'sending',
+    progress_message_id INT,
+    created_at TIMESTAMP DEFAULT now(),
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_recipients (
+    broadcast_id INT REFERENCES broadcasts(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id),
+    status TEXT DEFAULT 'pending',
+    error TEXT,
+    sent_at TIMESTAMP,
+    PRIMARY KEY (broadcast_id, user_id)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS outgoing_messages (
+    id BIGSERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    text TEXT,
+    photo_file_id TEXT,
+    parse_mode TEXT,
+    reply_markup TEXT,
+    status TEXT DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT now(),
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT now(),
+    sent_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outgoing_messages_due_idx ON outgoing_messages (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS message_templates (
+    key TEXT NOT NULL,
+    language TEXT NOT NULL,
+    body TEXT NOT NULL,
+    updated_by BIGINT,
+    updated_at TIMESTAMP DEFAULT now(),
+    PRIMARY KEY (key, language)
+);
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS support_tickets (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id),
+    status TEXT DEFAULT 'open',
+    created_at TIMESTAMP DEFAULT now(),
+    updated_at TIMESTAMP DEFAULT now(),
+    closed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS support_tickets_user_idx ON support_tickets (user_id, status);
+
+CREATE TABLE IF NOT EXISTS support_messages (
+    id SERIAL PRIMARY KEY,
+    ticket_id INT REFERENCES support_tickets(id) ON DELETE CASCADE,
+    from_admin BOOLEAN NOT NULL DEFAULT false,
+    sender_id BIGINT NOT NULL,
+    text TEXT,
+    photo_file_id TEXT,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+-- Messages posted to the support chat, so admin replies can be routed back
+-- to the ticket.
+CREATE TABLE IF NOT EXISTS support_links (
+    chat_id BIGINT NOT NULL,
+    message_id INT NOT NULL,
+    ticket_id INT REFERENCES support_tickets(id) ON DELETE CASCADE,
+    PRIMARY KEY (chat_id, message_id)
+);
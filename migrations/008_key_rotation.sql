@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS key_rotated_at TIMESTAMPTZ;
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS trial_used_at TIMESTAMPTZ;
+
+-- Users who already had a key before trials existed have used theirs.
+UPDATE users SET trial_used_at = now() WHERE key_id IS NOT NULL AND trial_used_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_key_id_idx ON users (key_id) WHERE key_id IS NOT NULL;
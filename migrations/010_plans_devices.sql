@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS plans (
+    id SERIAL PRIMARY KEY,
+    code TEXT UNIQUE NOT NULL,
+    title TEXT NOT NULL,
+    days INT NOT NULL,
+    devices INT NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS plans_default_idx ON plans (is_default) WHERE is_default;
+
+INSERT INTO plans (code, title, days, devices, is_default) VALUES
+    ('basic', 'Basic', 30, 1, true),
+    ('plus', 'Plus', 30, 3, false),
+    ('max', 'Max', 30, 5, false)
+ON CONFLICT (code) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan_id INT REFERENCES plans(id);
+
+-- Additional device keys. The user's first key stays in users.key_id.
+CREATE TABLE IF NOT EXISTS devices (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    name TEXT NOT NULL,
+    key_id TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS devices_user_idx ON devices (user_id);
@@ -0,0 +1,17 @@
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS family_members INT NOT NULL DEFAULT 0;
+
+UPDATE plans SET family_members = 2 WHERE code = 'plus';
+UPDATE plans SET family_members = 4 WHERE code = 'max';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS family_owner_id INT REFERENCES users(id);
+
+CREATE INDEX IF NOT EXISTS users_family_owner_idx ON users (family_owner_id) WHERE family_owner_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS family_invites (
+    code TEXT PRIMARY KEY,
+    owner_id INT NOT NULL REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_by INT REFERENCES users(id),
+    used_at TIMESTAMPTZ
+);
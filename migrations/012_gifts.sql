@@ -0,0 +1,12 @@
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS is_gift BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS gift_codes (
+    code TEXT PRIMARY KEY,
+    buyer_id INT NOT NULL REFERENCES users(id),
+    payment_id INT UNIQUE NOT NULL REFERENCES payments(id),
+    plan_id INT NOT NULL REFERENCES plans(id),
+    days INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    redeemed_by INT REFERENCES users(id),
+    redeemed_at TIMESTAMPTZ
+);
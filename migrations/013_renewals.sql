@@ -0,0 +1,17 @@
+-- Renewals apply a confirmed payment in steps that are retried until done.
+-- expires_at is fixed when the renewal is created, so repeating a step never
+-- extends twice.
+CREATE TABLE IF NOT EXISTS renewals (
+    id SERIAL PRIMARY KEY,
+    payment_id INT UNIQUE NOT NULL REFERENCES payments(id),
+    user_id INT NOT NULL REFERENCES users(id),
+    expires_at TIMESTAMPTZ NOT NULL,
+    state TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS renewals_due_idx ON renewals (next_attempt_at) WHERE state IN ('pending', 'panel_done');
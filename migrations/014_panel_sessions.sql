@@ -0,0 +1,8 @@
+-- Panel login sessions, reused across restarts while they are valid.
+CREATE TABLE IF NOT EXISTS panel_sessions (
+    panel_url TEXT PRIMARY KEY,
+    cookie_name TEXT NOT NULL,
+    cookie_value TEXT NOT NULL,
+    expires_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
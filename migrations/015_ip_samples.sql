@@ -0,0 +1,25 @@
+-- Addresses keys were seen connecting from, sampled from the panel to detect
+-- shared accounts. network is the /24 (IPv4) or /48 (IPv6) of ip.
+CREATE TABLE IF NOT EXISTS ip_samples (
+    key_id TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    user_id INT NOT NULL REFERENCES users(id),
+    network TEXT NOT NULL,
+    asn INT,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (key_id, ip)
+);
+
+CREATE INDEX IF NOT EXISTS ip_samples_user_idx ON ip_samples (user_id, last_seen_at);
+
+-- Users flagged for sharing, so one episode is reported once per window.
+CREATE TABLE IF NOT EXISTS sharing_flags (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    networks INT NOT NULL,
+    action TEXT NOT NULL,
+    flagged_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sharing_flags_user_idx ON sharing_flags (user_id, flagged_at);
@@ -0,0 +1,3 @@
+-- When the user's keys were disabled for account sharing. Cleared by
+-- /unsuspend or by a renewal, which re-enables the keys on the panel.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+-- in_log marks samples whose address is still in the panel's address log.
+-- Undated log entries say nothing about when they were seen, so their
+-- samples are kept past the window to tell them from newly logged ones.
+ALTER TABLE ip_samples ADD COLUMN IF NOT EXISTS in_log BOOLEAN NOT NULL DEFAULT false;
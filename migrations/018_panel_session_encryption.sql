@@ -0,0 +1,3 @@
+-- Panel sessions are now saved encrypted with PANEL_SESSION_KEY; drop the
+-- ones saved in plaintext, the bot logs in again.
+DELETE FROM panel_sessions;